@@ -3,17 +3,31 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"container/list"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -22,55 +36,291 @@ var (
 )
 
 const (
-	APPNAME           = "unix2date"
-	MIN_UNIXTIME      = 1000000000000 // 2001-09-09T01:46:40.000Z
-	MAX_UNIXTIME      = 2999999999999 // 2065-01-24T05:19:59.999Z
-	DEF_QUOTATIONS    = `"`
-	DEF_SEPARATORS    = ` ,\t`
-	DATETIME_FORMAT10 = "2006-01-02T15:04:05Z"
-	DATETIME_FORMAT13 = "2006-01-02T15:04:05.000Z"
-	UNIXTIME_PATTERN  = `([12](?:\d{12}|\d{9}))`
-	TYPE_JSON         = iota
+	APPNAME            = "unix2date"
+	MIN_UNIXTIME       = 1000000000000 // 2001-09-09T01:46:40.000Z
+	MAX_UNIXTIME       = 2999999999999 // 2065-01-24T05:19:59.999Z
+	DEF_QUOTATIONS     = `"`
+	DEF_SEPARATORS     = ` ,\t`
+	DEF_ANNOTATE_TMPL  = `{{.Original}} [{{.Datetime}}]`
+	DEF_GRANULARITY    = "auto"
+	RELATIVE_TMPL      = `{{.Age}}`
+	ABS_RELATIVE_TMPL  = `{{.Datetime}} ({{.Age}})`
+	DELTA_PREV         = "prev"
+	DELTA_FIRST        = "first"
+	SUMMARY_TO_STDOUT  = "stdout"
+	SUMMARY_TO_STDERR  = "stderr"
+	FORMAT_JSON        = "json"
+	FORMAT_TABLE       = "table"
+	FORMAT_CSV         = "csv"
+	FORMAT_YAML        = "yaml"
+	FORMAT_PROM        = "prom"
+	ORDER_LINE         = "line"
+	ORDER_KEY          = "key"
+	ORDER_MAX_LINES    = 100 // number of out-of-order line numbers listed in summary
+	EXIT_NO_MATCH      = 1
+	EXIT_USAGE         = 2
+	EXIT_OUT_OF_ORDER  = 3
+	EXIT_IO_ERROR      = 4
+	CHUNK_LINES        = 512
+	REORDER_CHUNKS     = 64
+	DEF_REORDER_LINES  = CHUNK_LINES * REORDER_CHUNKS
+	DEF_REORDER_BYTES  = "64M"
+	OUTPUT_BUFFER_SIZE = 256 * 1024
+	SEEK_LINEAR_BYTES  = 64 * 1024 // --sorted reads lines one by one below this range
+	COUNT_BUFFER_SIZE  = 256 * 1024
+	DEF_STOP_AFTER     = 1
+	DEF_SORT_BUFFER    = "64M"
+	SORT_ASC           = "asc"
+	SORT_DESC          = "desc"
+	UNTIMED_INHERIT    = "inherit"
+	UNTIMED_FIRST      = "first"
+	UNTIMED_LAST       = "last"
+	SORT_LINE_OVERHEAD = 64 // approximate memory usage of a sorted line other than its text
+	SPLIT_HOUR         = "hour"
+	SPLIT_DAY          = "day"
+	DEF_NAME_TMPL      = `{{.Date}}.log`
+	DEF_MAX_OPEN_FILES = 16
+	UNTIMED_BUCKET     = "untimed" // {{.Date}} of lines before the first unixtime
+	SPLIT_BUFFER_SIZE  = 64 * 1024
+	OUTPUT_TEXT        = "text"
+	OUTPUT_NDJSON      = "ndjson"
+	DEF_MARK_PREFIX    = ">> "
+	MARK_IN            = "IN"
+	MARK_OUT           = "OUT"
+	MARK_NONE          = "--"
+	COLOR_AUTO         = "auto"
+	COLOR_ALWAYS       = "always"
+	COLOR_NEVER        = "never"
+	SGR_RESET          = "\x1b[0m"
+	SGR_DIM            = "\x1b[2m"
+	SGR_RED            = "\x1b[1;31m" // within the filter period
+	SGR_MAGENTA        = "\x1b[35m"   // future
+	SGR_YELLOW         = "\x1b[33m"   // within an hour
+	SGR_GREEN          = "\x1b[32m"   // within a day
+	SGR_CYAN           = "\x1b[36m"   // older
+	HISTOGRAM_AUTO     = "auto"
+	HISTOGRAM_BUCKETS  = 60    // number of buckets aimed by --histogram auto
+	HISTOGRAM_MAX      = 10000 // empty buckets are omitted above this number
+	HISTOGRAM_BAR_LEN  = 50
+	DATETIME_FORMAT10  = "2006-01-02T15:04:05Z"
+	DATETIME_FORMAT13  = "2006-01-02T15:04:05.000Z"
+	TYPE_JSON          = iota
 	TYPE_QT
 	TYPE_SP
 )
 
 type FlagVariables struct {
-	noConvFlag  bool
-	invertFlag  bool
-	summaryFlag bool
-	filterFrom  string
-	filterTo    string
-	quotations  string
-	separators  string
+	noConvFlag       bool
+	invertFlag       bool
+	summaryFlag      bool
+	annotateFlag     bool
+	failOnDisorder   bool
+	relativeFlag     bool
+	absoluteFlag     bool
+	sortedFlag       bool
+	filterFrom       string
+	filterTo         string
+	quotations       string
+	separators       string
+	annotateTemplate string
+	now              string
+	granularity      string
+	delta            string
+	gapThreshold     string
+	histogram        string
+	statsFlag        bool
+	gaps             string
+	checkOrder       string
+	orderTolerance   string
+	summaryTo        string
+	summaryFormat    string
+	reorderBytes     string
+	jobs             int
+	reorderLines     int64
+	stopAfter        int64
+	afterContext     int64
+	beforeContext    int64
+	context          int64
+	recordStart      string
+	inheritFlag      bool
+	mergeFlag        bool
+	mergeKey         string
+	sortOrder        string
+	sortBuffer       string
+	untimed          string
+	splitBy          string
+	outputDir        string
+	nameTemplate     string
+	maxOpenFiles     int
+	outputFormat     string
+	quietFlag        bool
+	countFlag        bool
+	maxCount         int64
+	color            string
+	markFlag         bool
+	markPrefix       string
+	markColumnFlag   bool
+	files            []string
 }
 
 type Parameter struct {
-	filterFlag      bool
-	noConvFlag      bool
-	invertFlag      bool
-	summaryFlag     bool
-	filterFromMS    int64
-	filterToMS      int64
-	replacePatterns []ReplacePattern
+	filterFlag       bool
+	noConvFlag       bool
+	invertFlag       bool
+	summaryFlag      bool
+	summaryEnabled   bool
+	sortedFlag       bool
+	stopAfter        int64
+	afterContext     int64
+	beforeContext    int64
+	recordMode       bool
+	recordStart      *regexp.Regexp
+	mergeFlag        bool
+	mergeKey         string
+	sortOrder        string
+	sortBuffer       int64
+	untimed          string
+	splitBy          string
+	outputDir        string
+	nameTemplate     *template.Template
+	maxOpenFiles     int
+	outputFormat     string
+	quietFlag        bool
+	countFlag        bool
+	maxCount         int64
+	colorFlag        bool
+	markFlag         bool
+	markPrefix       string
+	markColumnFlag   bool
+	jobs             int
+	reorderLines     int64
+	reorderBytes     int64
+	summaryFormat    string
+	filterFromMS     int64
+	filterToMS       int64
+	detector         *Detector
+	annotateTemplate *template.Template
+	now              time.Time
+	granularity      time.Duration
+	delta            string
+	gapThreshold     time.Duration
+	histogramFlag    bool
+	statsFlag        bool
+	histogramWidthMS int64
+	gapsThreshold    time.Duration
+	orderMode        string
+	orderToleranceMS int64
+	failOnDisorder   bool
 }
 
-type ReplacePattern struct {
-	Regexp *regexp.Regexp
-	Type   int
+// Detector finds unixtime surrounded by separators, quotations, or in JSON values.
+// Characters of separators and quotations are given as a regexp character class.
+type Detector struct {
+	separators *charSet
+	quotations *charSet
+}
+
+type charSet struct {
+	ascii  [utf8.RuneSelf]bool
+	regexp *regexp.Regexp
 }
 
 type Summary struct {
 	mu                           *sync.Mutex
-	TotalNumberOfLines           int64  `json:"TotalNumberOfLines"`
-	TotalNumberOfUnixtime        int64  `json:"TotalNumberOfUnixtime"`
-	NumberOfLinesContainUnixtime int64  `json:"NumberOfLinesContainUnixtime"`
-	NumberOfLinesWithoutUnixtime int64  `json:"NumberOfLinesWithoutUnixtime"`
-	OldestUnixtime               int64  `json:"-"`
-	OldestDatetime               string `json:"OldestDatetime,omitempty"`
-	NewestUnixtime               int64  `json:"-"`
-	NewestDatetime               string `json:"NewestDatetime,omitempty"`
-	FilterCommandExample         string `json:"FilterCommandExample,omitempty"`
+	TotalNumberOfLines           int64                     `json:"TotalNumberOfLines"`
+	TotalNumberOfUnixtime        int64                     `json:"TotalNumberOfUnixtime"`
+	NumberOfLinesContainUnixtime int64                     `json:"NumberOfLinesContainUnixtime"`
+	NumberOfLinesWithoutUnixtime int64                     `json:"NumberOfLinesWithoutUnixtime"`
+	NumberOfMatchedLines         int64                     `json:"NumberOfMatchedLines"`
+	NumberOfEmittedLines         int64                     `json:"NumberOfEmittedLines"`
+	StoppedEarly                 bool                      `json:"StoppedEarly,omitempty"`
+	StoppedAtLine                int64                     `json:"StoppedAtLine,omitempty"`
+	OldestUnixtime               int64                     `json:"-"`
+	OldestDatetime               string                    `json:"OldestDatetime,omitempty"`
+	NewestUnixtime               int64                     `json:"-"`
+	NewestDatetime               string                    `json:"NewestDatetime,omitempty"`
+	FilterCommandExample         string                    `json:"FilterCommandExample,omitempty"`
+	MatchedOldestUnixtime        int64                     `json:"-"`
+	MatchedOldestDatetime        string                    `json:"MatchedOldestDatetime,omitempty"`
+	MatchedNewestUnixtime        int64                     `json:"-"`
+	MatchedNewestDatetime        string                    `json:"MatchedNewestDatetime,omitempty"`
+	DurationSeconds              float64                   `json:"DurationSeconds"`
+	NumberOfDistinctUnixtime     int64                     `json:"NumberOfDistinctUnixtime,omitempty"`
+	NumberOfUnixtimeByDetector   map[string]int64          `json:"NumberOfUnixtimeByDetector,omitempty"`
+	EventsPerSecond              *EventRate                `json:"EventsPerSecond,omitempty"`
+	EventsPerMinute              *EventRate                `json:"EventsPerMinute,omitempty"`
+	IntervalMilliseconds         *Percentiles              `json:"IntervalMilliseconds,omitempty"`
+	Keys                         map[string]*KeyStatistics `json:"Keys,omitempty"`
+	Histogram                    *Histogram                `json:"Histogram,omitempty"`
+	Gaps                         *GapReport                `json:"Gaps,omitempty"`
+	OutOfOrder                   *OrderReport              `json:"OutOfOrder,omitempty"`
+	Records                      *RecordReport             `json:"Records,omitempty"`
+	histogramBuckets             map[int64]*HistogramBucket
+	gapsNewestUnixtime           int64
+	gapsNewestLine               int64
+	orderPrevUnixtime            map[string]int64
+	unixtimeCounts               map[int64]int64 // every distinct unixtime, kept only with --stats
+}
+
+type KeyStatistics struct {
+	NumberOfUnixtime int64  `json:"NumberOfUnixtime"`
+	OldestUnixtime   int64  `json:"-"`
+	OldestDatetime   string `json:"OldestDatetime"`
+	NewestUnixtime   int64  `json:"-"`
+	NewestDatetime   string `json:"NewestDatetime"`
+}
+
+type EventRate struct {
+	Mean       float64 `json:"Mean"`
+	Peak       int64   `json:"Peak"`
+	PeakWindow string  `json:"PeakWindow"`
+}
+
+type Percentiles struct {
+	P50 int64 `json:"P50"`
+	P90 int64 `json:"P90"`
+	P99 int64 `json:"P99"`
+	Max int64 `json:"Max"`
+}
+
+type OrderReport struct {
+	Mode                string  `json:"Mode"`
+	Tolerance           string  `json:"Tolerance"`
+	NumberOfOutOfOrder  int64   `json:"NumberOfOutOfOrder"`
+	WorstRegression     string  `json:"WorstRegression,omitempty"`
+	WorstRegressionLine int64   `json:"WorstRegressionLine,omitempty"`
+	OutOfOrderLines     []int64 `json:"OutOfOrderLines"`
+	worstRegressionMS   int64
+}
+
+type RecordReport struct {
+	NumberOfRecords        int64 `json:"NumberOfRecords"`
+	NumberOfMatchedRecords int64 `json:"NumberOfMatchedRecords"`
+}
+
+type Histogram struct {
+	BucketWidth string             `json:"BucketWidth"`
+	Buckets     []*HistogramBucket `json:"Buckets"`
+}
+
+type GapReport struct {
+	Threshold string `json:"Threshold"`
+	Gaps      []*Gap `json:"Gaps"`
+}
+
+type Gap struct {
+	Start     string `json:"Start"`
+	End       string `json:"End"`
+	Duration  string `json:"Duration"`
+	StartLine int64  `json:"StartLine"`
+	EndLine   int64  `json:"EndLine"`
+}
+
+type HistogramBucket struct {
+	StartUnixtime    int64  `json:"-"`
+	Start            string `json:"Start"`
+	NumberOfUnixtime int64  `json:"NumberOfUnixtime"`
+	NumberOfLines    int64  `json:"NumberOfLines"`
 }
 
 type Input struct {
@@ -78,227 +328,2720 @@ type Input struct {
 	Text  string
 }
 
-type Result struct {
-	Index        int64
-	Text         string
-	NeedToOutput bool
+type Result struct {
+	Index          int64
+	Text           string
+	Original       string // set only with --output ndjson
+	Source         string // name of the input file of the line, set only by merge command
+	NeedToOutput   bool
+	InFilterPeriod bool
+	RecordStart    bool
+	Matches        []*Match
+}
+
+type Match struct {
+	Unixtime  int64 // milliseconds
+	Key       string
+	Type      int
+	Offset    int    // byte offset in the original line
+	Raw       string // unixtime string in the original line
+	InWindow  bool   // within the filter period, or always true without filter
+	TextStart int    // span of the converted datetime in the converted line
+	TextEnd   int
+}
+
+// Event is a line output with --output ndjson.
+type Event struct {
+	Line      int64         `json:"Line"`
+	Source    string        `json:"Source,omitempty"`
+	Original  string        `json:"Original"`
+	Converted string        `json:"Converted"`
+	Matches   []*EventMatch `json:"Matches"`
+}
+
+type EventMatch struct {
+	Offset   int    `json:"Offset"`
+	Raw      string `json:"Raw"`
+	Unit     string `json:"Unit"`
+	Detector string `json:"Detector"`
+	Key      string `json:"Key,omitempty"`
+	Datetime string `json:"Datetime"`
+	EpochMS  int64  `json:"EpochMS"`
+	InWindow bool   `json:"InWindow"`
+}
+
+type Chunk struct {
+	Seq     int64
+	Bytes   int64
+	Inputs  []*Input
+	Results []*Result
+}
+
+type Output struct {
+	Writer          io.Writer
+	Param           *Parameter
+	Summary         *Summary
+	FirstUnixtime   int64
+	PrevUnixtime    int64
+	LinesAfterRange int64 // consecutive lines whose first unixtime is after --filter-to
+	Stopped         bool
+	Hits            int64     // number of lines output except context lines, limited by --max-count
+	Count           int64     // number of lines counted with --count in the current input
+	BeforeContext   []*Result // lines held for --before-context
+	AfterContext    int64     // number of lines left to output as --after-context
+	ContextOutput   bool      // any line is output with context
+	ContextSkipped  bool      // lines are skipped since the last line output with context
+	Source          string    // name of the input file of lines
+	FirstLine       int64     // index of the first line read from the input, skipped by --sorted
+	Record          []*Result // lines of the record held until it ends
+	RecordInPeriod  bool
+	RecordDecided   bool
+}
+
+type ReplaceInfo struct {
+	Type        int
+	UnixtimeStr string
+	StartIndex  int
+	EndIndex    int
+	TimeFormat  string
+	NeedQuote   bool
+	NeedEscape  bool
+}
+
+type Annotation struct {
+	Original string
+	Datetime string
+	Age      string
+}
+
+func main() {
+	s := &Summary{mu: &sync.Mutex{}}
+	fv, fs := parseFlagSet(os.Args[1:])
+	if VersionFlag {
+		fmt.Println(Version)
+		os.Exit(0)
+	}
+	p, err := validateFlagVariables(fv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(EXIT_USAGE)
+	}
+	if p.gapsThreshold > 0 {
+		s.Gaps = &GapReport{Threshold: p.gapsThreshold.String(), Gaps: []*Gap{}}
+	}
+	summaryWriter, err := openSummaryWriter(fv.summaryTo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(EXIT_IO_ERROR)
+	}
+	if p.recordMode {
+		s.Records = &RecordReport{}
+	}
+	if p.orderMode != "" {
+		s.OutOfOrder = &OrderReport{
+			Mode:            p.orderMode,
+			Tolerance:       (time.Duration(p.orderToleranceMS) * time.Millisecond).String(),
+			OutOfOrderLines: []int64{},
+		}
+	}
+
+	inputs := fv.files
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	var stdout io.Writer = os.Stdout
+	if p.quietFlag {
+		stdout = io.Discard
+	}
+	writer := bufio.NewWriterSize(stdout, OUTPUT_BUFFER_SIZE)
+	output := &Output{Writer: writer, Param: p, Summary: s}
+	var splitter *splitWriter
+	if p.splitBy != "" {
+		splitter = newSplitWriter(p)
+		output.Writer = splitter
+	}
+	if p.mergeFlag {
+		err = runMerge(inputs, output, s, p)
+	} else if p.sortOrder != "" {
+		err = runSorted(inputs, output, s, p)
+	} else {
+		for _, name := range inputs {
+			err = runInput(name, output, s, p)
+			if err == nil && p.countFlag {
+				outputCount(output, name, len(inputs) > 1)
+			}
+			if err != nil || output.Stopped {
+				break
+			}
+		}
+	}
+	// write errors on stdout are kept by the writer and returned at the last flush
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	// stdout was closed by the reader (ex. | head) while --sort was running,
+	// which ends quietly like SIGPIPE does without --sort
+	if errors.Is(err, syscall.EPIPE) {
+		err = nil
+	}
+	if splitter != nil {
+		if closeErr := splitter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(EXIT_IO_ERROR)
+	}
+
+	if p.summaryEnabled {
+		err = outputSummary(summaryWriter, s, p)
+		if closeErr := summaryWriter.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(EXIT_IO_ERROR)
+		}
+	}
+
+	if p.failOnDisorder && s.OutOfOrder.NumberOfOutOfOrder > 0 {
+		os.Exit(EXIT_OUT_OF_ORDER)
+	}
+	os.Exit(exitStatus(s, p))
+}
+
+// exitStatus returns 0 when any line is matched with -f/-t, or any unixtime is
+// converted without them, like grep.
+func exitStatus(s *Summary, p *Parameter) int {
+	if p.filterFlag && s.NumberOfMatchedLines > 0 || !p.filterFlag && s.TotalNumberOfUnixtime > 0 {
+		return 0
+	}
+	return EXIT_NO_MATCH
+}
+
+// openSummaryWriter returns the destination of summary specified by --summary-to.
+func openSummaryWriter(summaryTo string) (io.WriteCloser, error) {
+	switch summaryTo {
+	case "", SUMMARY_TO_STDOUT:
+		return nopCloser{os.Stdout}, nil
+	case SUMMARY_TO_STDERR:
+		return nopCloser{os.Stderr}, nil
+	}
+	return os.Create(summaryTo)
+}
+
+type nopCloser struct {
+	*os.File
+}
+
+func (nopCloser) Close() error { return nil }
+
+// runInput converts lines of the file, or stdin if name is "-".
+func runInput(name string, output *Output, s *Summary, p *Parameter) error {
+	r, closer, firstLine, err := openInput(name, p)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	output.Source = name
+	output.FirstLine = firstLine
+	return run(r, output, s, p)
+}
+
+// openInput opens the file, or stdin if name is "-". With --sorted, only the range
+// of a regular file which can contain lines within the filter period is read, and
+// the index of its first line in the file is returned so that lines keep their numbers.
+func openInput(name string, p *Parameter) (io.Reader, io.Closer, int64, error) {
+	var f *os.File
+	var closer io.Closer
+	if name == "-" {
+		f = os.Stdin
+		closer = nopCloser{f}
+	} else {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return nil, nil, 0, err
+		}
+		closer = f
+	}
+	if p.sortedFlag {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			start, end, err := sortedRange(f, info.Size(), p)
+			if err != nil {
+				f.Close()
+				return nil, nil, 0, err
+			}
+			firstLine, err := countLines(f, start)
+			if err != nil {
+				f.Close()
+				return nil, nil, 0, err
+			}
+			return io.NewSectionReader(f, start, end-start), closer, firstLine, nil
+		}
+	}
+	return f, closer, 0, nil
+}
+
+// countLines returns the number of lines in the first n bytes of r.
+func countLines(r io.ReaderAt, n int64) (int64, error) {
+	buf := make([]byte, COUNT_BUFFER_SIZE)
+	sr := io.NewSectionReader(r, 0, n)
+	var count int64
+	for {
+		read, err := sr.Read(buf)
+		count += int64(bytes.Count(buf[:read], []byte{'\n'}))
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// mergeSource is an input of merge command. Its next line is held in head
+// until it is output in order of unixtime.
+type mergeSource struct {
+	name     string
+	index    int
+	lines    chan string
+	head     *Result
+	unixtime int64 // unixtime of head, or of the previous line if head has no unixtime
+	line     int64
+	err      error
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].unixtime != h[j].unixtime {
+		return h[i].unixtime < h[j].unixtime
+	}
+	return h[i].index < h[j].index
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// runMerge outputs lines of files merged in order of unixtime (merge command).
+// Each line is ordered by the unixtime of --merge-key, or its first unixtime,
+// and lines without unixtime follow the previous line of the same file.
+// Lines with the same unixtime are output in order of files. Only a few lines
+// of each file are held, so that memory stays bounded.
+func runMerge(names []string, output *Output, s *Summary, p *Parameter) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	sources := make([]*mergeSource, len(names))
+	for i, name := range names {
+		r, closer, firstLine, err := openInput(name, p)
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		src := &mergeSource{name: name, index: i, lines: make(chan string, CHUNK_LINES), line: firstLine}
+		go func() {
+			src.err = scanLines(r, src.lines, stop)
+			close(src.lines)
+		}()
+		sources[i] = src
+	}
+
+	h := &mergeHeap{}
+	for _, src := range sources {
+		if nextMergeLine(output, src, s, p) {
+			heap.Push(h, src)
+		}
+	}
+	output.BeforeContext = nil
+	output.AfterContext = 0
+	for h.Len() > 0 {
+		src := (*h)[0]
+		result := src.head
+		result.Source = src.name
+		processResult(output, result)
+		if output.Stopped {
+			return nil
+		}
+		if nextMergeLine(output, src, s, p) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	endRecord(output)
+	for _, src := range sources {
+		if src.err != nil {
+			return fmt.Errorf("%s: %v", src.name, src.err)
+		}
+	}
+	return nil
+}
+
+// runSorted converts lines of files sorted by unixtime (--sort).
+func runSorted(names []string, output *Output, s *Summary, p *Parameter) error {
+	// while temporary files may exist, a write to a closed pipe returns EPIPE
+	// instead of killing the process, so that they are removed
+	sigpipe := make(chan os.Signal, 1)
+	signal.Notify(sigpipe, syscall.SIGPIPE)
+	defer signal.Stop(sigpipe)
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(sortInputs(names, pw, p))
+	}()
+	err := run(pr, output, s, p)
+	// stop sorting when run stopped early, and wait until temporary files are removed
+	pr.Close()
+	<-done
+	return err
+}
+
+type sortLine struct {
+	key  int64
+	text string
+}
+
+// sortInputs writes lines of files to w in order of the first unixtime of each line.
+// The sort is stable, and lines without unixtime are placed by --untimed.
+// Lines are sorted in memory up to --sort-buffer. Beyond that, sorted runs are
+// written to temporary files and merged.
+func sortInputs(names []string, w io.Writer, p *Parameter) error {
+	var lines []sortLine
+	var runs []string
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+	var bufferSize int64
+	prevKey := int64(math.MinInt64) // lines before the first unixtime are placed first
+	for _, name := range names {
+		r, closer, _, err := openInput(name, p)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			text := scanner.Text()
+			key, ok := firstUnixtime(text, p)
+			switch {
+			case ok && p.sortOrder == SORT_DESC:
+				key = -key
+			case ok:
+			case p.untimed == UNTIMED_FIRST:
+				key = math.MinInt64
+			case p.untimed == UNTIMED_LAST:
+				key = math.MaxInt64
+			default:
+				key = prevKey
+			}
+			if ok {
+				prevKey = key
+			}
+			lines = append(lines, sortLine{key: key, text: text})
+			bufferSize += int64(len(text)) + SORT_LINE_OVERHEAD
+			if bufferSize >= p.sortBuffer {
+				run, err := writeSortRun(lines)
+				if err != nil {
+					closer.Close()
+					return err
+				}
+				runs = append(runs, run)
+				lines = nil
+				bufferSize = 0
+			}
+		}
+		closer.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if len(runs) == 0 {
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].key < lines[j].key })
+		bw := bufio.NewWriter(w)
+		for _, line := range lines {
+			fmt.Fprintln(bw, line.text)
+		}
+		return bw.Flush()
+	}
+	if len(lines) > 0 {
+		run, err := writeSortRun(lines)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+	return mergeSortRuns(runs, w)
+}
+
+// writeSortRun sorts lines and writes them with their keys to a temporary file.
+func writeSortRun(lines []sortLine) (string, error) {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].key < lines[j].key })
+	f, err := os.CreateTemp("", APPNAME+"-sort-*")
+	if err != nil {
+		return "", err
+	}
+	bw := bufio.NewWriter(f)
+	for _, line := range lines {
+		fmt.Fprintf(bw, "%d\t%s\n", line.key, line.text)
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return f.Name(), err
+	}
+	return f.Name(), f.Close()
+}
+
+// mergeSortRuns merges sorted runs with the heap of merge command. Runs are
+// ordered as they are read, so lines with the same key keep the input order.
+func mergeSortRuns(runs []string, w io.Writer) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	h := &mergeHeap{}
+	next := func(src *mergeSource) bool {
+		text, ok := <-src.lines
+		if !ok {
+			return false
+		}
+		keyStr, text, _ := strings.Cut(text, "\t")
+		src.unixtime, _ = strconv.ParseInt(keyStr, 10, 64)
+		src.head = &Result{Text: text}
+		return true
+	}
+	sources := make([]*mergeSource, len(runs))
+	for i, run := range runs {
+		f, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src := &mergeSource{name: run, index: i, lines: make(chan string, CHUNK_LINES)}
+		go func() {
+			src.err = scanLines(f, src.lines, stop)
+			close(src.lines)
+		}()
+		sources[i] = src
+		if next(src) {
+			heap.Push(h, src)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	for h.Len() > 0 {
+		src := (*h)[0]
+		if _, err := fmt.Fprintln(bw, src.head.Text); err != nil {
+			return err
+		}
+		if next(src) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	for _, src := range sources {
+		if src.err != nil {
+			return src.err
+		}
+	}
+	return bw.Flush()
+}
+
+// nextMergeLine converts the next line of src into src.head.
+// It returns false at the end of src.
+func nextMergeLine(output *Output, src *mergeSource, s *Summary, p *Parameter) bool {
+	var text string
+	var ok bool
+	select {
+	case text, ok = <-src.lines:
+	default:
+		flushOutput(output)
+		text, ok = <-src.lines
+	}
+	if !ok {
+		return false
+	}
+	src.head = replaceUnixtimeToDatetime(&Input{Index: src.line, Text: text}, s, p)
+	src.line++
+	if len(src.head.Matches) > 0 {
+		src.unixtime = src.head.Matches[0].Unixtime
+		for _, m := range src.head.Matches {
+			if m.Key == p.mergeKey {
+				src.unixtime = m.Unixtime
+				break
+			}
+		}
+	}
+	return true
+}
+
+// sortedRange returns the byte range [start, end) of r sorted by unixtime, which
+// starts at the first line whose unixtime is at or after --filter-from and ends
+// before the first line whose unixtime is after --filter-to.
+// The first unixtime of each line is compared, and lines without unixtime are skipped.
+func sortedRange(r io.ReaderAt, size int64, p *Parameter) (int64, int64, error) {
+	start, err := seekUnixtime(r, 0, size, p.filterFromMS, p)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := seekUnixtime(r, start, size, p.filterToMS+1, p)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// seekUnixtime returns the offset of the first line at or after the line start from
+// whose unixtime is at or after targetMS, or size if there is no such line.
+// The range is narrowed by binary search, and then read line by line.
+func seekUnixtime(r io.ReaderAt, from, size, targetMS int64, p *Parameter) (int64, error) {
+	lo, hi := from, size
+	for hi-lo > SEEK_LINEAR_BYTES {
+		half := lo + (hi-lo)/2
+		mid, err := nextLineStart(r, half, size)
+		if err != nil {
+			return 0, err
+		}
+		line, err := findTimedLine(r, mid, hi, size, p)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case line == nil:
+			// no unixtime in the latter half, so the line is in the former half or at or after hi
+			hi = half
+		case line.unixtime < targetMS:
+			lo = line.end
+		default:
+			hi = line.start
+		}
+	}
+	for offset := lo; ; {
+		line, err := findTimedLine(r, offset, size, size, p)
+		if err != nil {
+			return 0, err
+		}
+		if line == nil {
+			return size, nil
+		}
+		if line.unixtime >= targetMS {
+			return line.start, nil
+		}
+		offset = line.end
+	}
+}
+
+type timedLine struct {
+	start    int64
+	end      int64
+	unixtime int64
+}
+
+// findTimedLine returns the first line with unixtime which starts in [offset, limit).
+// offset must be a line start.
+func findTimedLine(r io.ReaderAt, offset, limit, size int64, p *Parameter) (*timedLine, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, offset, size-offset))
+	for start := offset; start < limit; {
+		text, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(text) == 0 {
+			break
+		}
+		end := start + int64(len(text))
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		if unixtime, ok := firstUnixtime(text, p); ok {
+			return &timedLine{start: start, end: end, unixtime: unixtime}, nil
+		}
+		start = end
+	}
+	return nil, nil
+}
+
+// nextLineStart returns the offset of the line start at or after pos.
+func nextLineStart(r io.ReaderAt, pos, size int64) (int64, error) {
+	if pos == 0 {
+		return 0, nil
+	}
+	br := bufio.NewReader(io.NewSectionReader(r, pos-1, size-pos+1))
+	skipped, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	return pos - 1 + int64(len(skipped)), nil
+}
+
+// run converts lines read from r and writes them to output in input order.
+// Lines are batched into chunks and converted by --jobs workers. Converted chunks
+// are reassembled in order with a ring buffer. The reader stalls while the chunks
+// in flight exceed the reorder window (--reorder-lines, --reorder-bytes), so that
+// memory stays bounded even if an early chunk is slow.
+func run(r io.Reader, output *Output, s *Summary, p *Parameter) error {
+	// context lines do not continue over inputs
+	output.BeforeContext = nil
+	output.AfterContext = 0
+	output.ContextSkipped = true
+	if p.jobs == 1 {
+		return runSequential(r, output, s, p)
+	}
+	ring := make([]*Chunk, REORDER_CHUNKS)
+	window := newReorderWindow(p.reorderLines, p.reorderBytes)
+	jobs := make(chan *Chunk, REORDER_CHUNKS)
+	done := make(chan *Chunk, REORDER_CHUNKS)
+
+	var readErr error
+	go func() {
+		readErr = readChunks(r, jobs, window, output.FirstLine, p)
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range jobs {
+				chunk.Results = make([]*Result, len(chunk.Inputs))
+				for i, input := range chunk.Inputs {
+					chunk.Results[i] = replaceUnixtimeToDatetime(input, s, p)
+				}
+				done <- chunk
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var next int64
+	for {
+		var chunk *Chunk
+		select {
+		case chunk = <-done:
+		default:
+			// flush while waiting for input, so that output is not delayed on streaming input
+			flushOutput(output)
+			chunk = <-done
+		}
+		if chunk == nil {
+			endRecord(output)
+			break
+		}
+		ring[chunk.Seq%REORDER_CHUNKS] = chunk
+		for ring[next%REORDER_CHUNKS] != nil && ring[next%REORDER_CHUNKS].Seq == next {
+			chunk = ring[next%REORDER_CHUNKS]
+			for _, result := range chunk.Results {
+				processResult(output, result)
+				if output.Stopped {
+					// wait only for the chunks in flight, so that workers end. The reader
+					// stops without waiting for the rest of input.
+					window.stop()
+					for i, c := range ring {
+						if c != nil {
+							window.release(c)
+							ring[i] = nil
+						}
+					}
+					for window.inFlight() > 0 {
+						window.release(<-done)
+					}
+					return nil
+				}
+			}
+			ring[next%REORDER_CHUNKS] = nil
+			next++
+			window.release(chunk)
+		}
+	}
+	return readErr
+}
+
+// runSequential converts lines one by one without workers (--jobs 1).
+func runSequential(r io.Reader, output *Output, s *Summary, p *Parameter) error {
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+	var scanErr error
+	go func() {
+		scanErr = scanLines(r, lines, stop)
+		close(lines)
+	}()
+
+	lineCount := output.FirstLine
+	for {
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		default:
+			flushOutput(output)
+			line, ok = <-lines
+		}
+		if !ok {
+			endRecord(output)
+			break
+		}
+		processResult(output, replaceUnixtimeToDatetime(&Input{Index: lineCount, Text: line}, s, p))
+		if output.Stopped {
+			return nil
+		}
+		lineCount++
+	}
+	return scanErr
+}
+
+// scanLines sends lines read from r until the end of r or stop is closed.
+func scanLines(r io.Reader, lines chan<- string, stop <-chan struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		select {
+		case lines <- scanner.Text():
+		case <-stop:
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func flushOutput(output *Output) {
+	if w, ok := output.Writer.(*bufio.Writer); ok {
+		w.Flush()
+	}
+}
+
+// readChunks sends lines read from r to jobs in chunks of up to CHUNK_LINES lines.
+// A partial chunk is sent when no more line is available immediately.
+func readChunks(r io.Reader, jobs chan<- *Chunk, window *reorderWindow, firstLine int64, p *Parameter) error {
+	lines := make(chan string, CHUNK_LINES)
+	stop := make(chan struct{})
+	defer close(stop)
+	var scanErr error
+	go func() {
+		scanErr = scanLines(r, lines, stop)
+		close(lines)
+	}()
+
+	chunkLines := int64(CHUNK_LINES)
+	if chunkLines > p.reorderLines {
+		chunkLines = p.reorderLines
+	}
+	var seq int64
+	lineCount := firstLine
+	chunk := &Chunk{Seq: seq}
+	send := func() bool {
+		if !window.acquire(chunk) {
+			return false
+		}
+		jobs <- chunk
+		seq++
+		chunk = &Chunk{Seq: seq}
+		return true
+	}
+	for {
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		default:
+			if len(chunk.Inputs) > 0 && !send() {
+				return nil
+			}
+			line, ok = <-lines
+		}
+		if !ok {
+			break
+		}
+		chunk.Inputs = append(chunk.Inputs, &Input{Index: lineCount, Text: line})
+		chunk.Bytes += int64(len(line))
+		lineCount++
+		if (int64(len(chunk.Inputs)) >= chunkLines || chunk.Bytes >= p.reorderBytes) && !send() {
+			return nil
+		}
+	}
+	if len(chunk.Inputs) > 0 && !send() {
+		return nil
+	}
+	return scanErr
+}
+
+// reorderWindow limits the chunks, lines and bytes which are read but not yet written.
+type reorderWindow struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	chunks   int64
+	lines    int64
+	bytes    int64
+	maxLines int64
+	maxBytes int64
+	stopped  bool
+}
+
+func newReorderWindow(maxLines, maxBytes int64) *reorderWindow {
+	w := &reorderWindow{maxLines: maxLines, maxBytes: maxBytes}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// acquire waits until the chunk fits in the window. A chunk is always accepted
+// when the window is empty, even if the chunk alone exceeds the limits.
+// It returns false without accepting the chunk after the window is stopped.
+func (w *reorderWindow) acquire(chunk *Chunk) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for !w.stopped && w.chunks > 0 && (w.chunks >= REORDER_CHUNKS ||
+		w.lines+int64(len(chunk.Inputs)) > w.maxLines ||
+		w.bytes+chunk.Bytes > w.maxBytes) {
+		w.cond.Wait()
+	}
+	if w.stopped {
+		return false
+	}
+	w.chunks++
+	w.lines += int64(len(chunk.Inputs))
+	w.bytes += chunk.Bytes
+	return true
+}
+
+func (w *reorderWindow) release(chunk *Chunk) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.chunks--
+	w.lines -= int64(len(chunk.Inputs))
+	w.bytes -= chunk.Bytes
+	w.cond.Signal()
+}
+
+func (w *reorderWindow) inFlight() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.chunks
+}
+
+// stop makes the reader waiting in acquire give up reading.
+func (w *reorderWindow) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	w.cond.Broadcast()
+}
+
+// processResult handles a converted line. It must be called in input order.
+func processResult(output *Output, result *Result) {
+	if output.Param.recordMode {
+		groupRecord(output, result)
+		return
+	}
+	processLine(output, result)
+}
+
+// groupRecord holds lines until the record ends, because a record is output when
+// any of its lines is matched. A record starts with a line matching --record-start,
+// or with a line containing unixtime with --inherit. A record longer than
+// --reorder-lines is decided by the lines held so far to bound memory usage.
+func groupRecord(output *Output, result *Result) {
+	if result.RecordStart {
+		endRecord(output)
+	}
+	if output.RecordDecided {
+		processRecordLine(output, result)
+		return
+	}
+	output.Record = append(output.Record, result)
+	if result.InFilterPeriod {
+		output.RecordInPeriod = true
+	}
+	if int64(len(output.Record)) >= output.Param.reorderLines {
+		decideRecord(output)
+	}
+}
+
+func decideRecord(output *Output) {
+	output.RecordDecided = true
+	output.Summary.Records.NumberOfRecords++
+	if recordMatched(output) {
+		output.Summary.Records.NumberOfMatchedRecords++
+	}
+	for _, result := range output.Record {
+		processRecordLine(output, result)
+		if output.Stopped {
+			break
+		}
+	}
+	output.Record = nil
+}
+
+// endRecord processes the lines of the record held so far. It must be called
+// at the end of input.
+func endRecord(output *Output) {
+	if !output.Param.recordMode {
+		return
+	}
+	if !output.RecordDecided && len(output.Record) > 0 {
+		decideRecord(output)
+	}
+	output.Record = nil
+	output.RecordInPeriod = false
+	output.RecordDecided = false
+}
+
+func recordMatched(output *Output) bool {
+	p := output.Param
+	return !p.filterFlag || p.invertFlag != output.RecordInPeriod
+}
+
+func processRecordLine(output *Output, result *Result) {
+	p := output.Param
+	result.NeedToOutput = (recordMatched(output) || p.markFlag) && !p.summaryFlag
+	// lines of a record share the period of the record, so the line is matched by
+	// the record in summary, and --color dims the whole record
+	result.InFilterPeriod = output.RecordInPeriod
+	processLine(output, result)
+}
+
+// processLine handles a line in input order.
+func processLine(output *Output, result *Result) {
+	updateSummary(output.Summary, result, output.Param)
+	if splitter, ok := output.Writer.(*splitWriter); ok {
+		splitter.selectFile(result)
+	}
+	if output.Param.maxCount > 0 && result.NeedToOutput {
+		// lines beyond --max-count can be still output as --after-context
+		if output.Hits < output.Param.maxCount {
+			output.Hits++
+		} else {
+			result.NeedToOutput = false
+		}
+	}
+	if output.Param.gapsThreshold > 0 {
+		detectGaps(output.Summary, result, output.Param)
+	}
+	if output.Param.orderMode != "" {
+		checkOrder(output.Summary, result, output.Param)
+	}
+	if output.Param.afterContext > 0 || output.Param.beforeContext > 0 {
+		outputWithContext(output, result)
+	} else if result.NeedToOutput {
+		if output.Param.countFlag {
+			output.Count++
+		} else {
+			outputResult(output, result)
+		}
+	}
+	if output.Param.stopAfter > 0 {
+		stopAfterRange(output, result)
+	}
+	if output.Param.maxCount > 0 && output.Hits >= output.Param.maxCount && output.AfterContext == 0 {
+		stop(output, result)
+	}
+}
+
+// outputCount writes the number of lines counted in an input (--count),
+// prefixed with the name of the input when there are several inputs.
+// Like grep, each input is counted up to --max-count, so the next input is read
+// even if this one stopped.
+func outputCount(output *Output, name string, withName bool) {
+	if withName {
+		fmt.Fprintf(output.Writer, "%s:", name)
+	}
+	fmt.Fprintln(output.Writer, output.Count)
+	output.Count = 0
+	output.Hits = 0
+	output.Stopped = false
+}
+
+// markText returns the head of a line with --mark and --mark-column. Lines out of
+// the filter period get spaces instead of the prefix to keep lines aligned.
+func markText(result *Result, p *Parameter) string {
+	var sb strings.Builder
+	if p.markColumnFlag {
+		switch {
+		case result.InFilterPeriod:
+			sb.WriteString(MARK_IN)
+		case len(result.Matches) == 0 && !p.recordMode:
+			sb.WriteString(MARK_NONE)
+		default:
+			sb.WriteString(MARK_OUT)
+		}
+		sb.WriteByte('\t')
+	}
+	if !result.InFilterPeriod {
+		sb.WriteString(strings.Repeat(" ", utf8.RuneCountInString(p.markPrefix)))
+		return sb.String()
+	}
+	sb.WriteString(p.markPrefix)
+	if p.colorFlag {
+		return SGR_RED + sb.String() + SGR_RESET
+	}
+	return sb.String()
+}
+
+// lineText returns the text of a line to output, prefixed with the name of the input
+// file by merge command. With --color, datetime is colored by its age (red within the
+// filter period), and lines out of the filter period are dimmed. SGR is reset at the
+// end of each line so that pagers like less -R show each line independently.
+func lineText(result *Result, p *Parameter) string {
+	prefix := ""
+	if result.Source != "" {
+		prefix = result.Source + ":"
+	}
+	if !p.colorFlag {
+		return prefix + result.Text
+	}
+	lineStyle := ""
+	if p.filterFlag && !result.InFilterPeriod {
+		lineStyle = SGR_DIM
+	}
+	if lineStyle == "" && len(result.Matches) == 0 {
+		return prefix + result.Text
+	}
+	var sb strings.Builder
+	sb.WriteString(lineStyle)
+	sb.WriteString(prefix)
+	last := 0
+	for _, m := range result.Matches {
+		start, end := m.TextStart, m.TextEnd
+		if p.noConvFlag {
+			start, end = m.Offset, m.Offset+len(m.Raw)
+		}
+		sb.WriteString(result.Text[last:start])
+		sb.WriteString(ageColor(m, p))
+		sb.WriteString(result.Text[start:end])
+		sb.WriteString(SGR_RESET + lineStyle)
+		last = end
+	}
+	sb.WriteString(result.Text[last:])
+	if lineStyle != "" {
+		sb.WriteString(SGR_RESET)
+	}
+	return sb.String()
+}
+
+func ageColor(m *Match, p *Parameter) string {
+	if p.filterFlag && m.InWindow {
+		return SGR_RED
+	}
+	age := time.Duration(p.now.UnixMilli()-m.Unixtime) * time.Millisecond
+	switch {
+	case age < 0:
+		return SGR_MAGENTA
+	case age < time.Hour:
+		return SGR_YELLOW
+	case age < 24*time.Hour:
+		return SGR_GREEN
+	}
+	return SGR_CYAN
+}
+
+// outputEvent writes a line as a JSON object (--output ndjson).
+func outputEvent(output *Output, result *Result) {
+	event := &Event{
+		Line:      result.Index + 1,
+		Source:    output.Source,
+		Original:  result.Original,
+		Converted: result.Text,
+		Matches:   make([]*EventMatch, len(result.Matches)),
+	}
+	if result.Source != "" {
+		event.Source = result.Source
+	}
+	for i, m := range result.Matches {
+		em := &EventMatch{
+			Offset:   m.Offset,
+			Raw:      m.Raw,
+			Unit:     "ms",
+			Detector: detectorNames[m.Type],
+			Key:      m.Key,
+			Datetime: time.UnixMilli(m.Unixtime).UTC().Format(DATETIME_FORMAT13),
+			EpochMS:  m.Unixtime,
+			InWindow: m.InWindow,
+		}
+		if len(m.Raw) == 10 {
+			em.Unit = "s"
+			em.Datetime = time.UnixMilli(m.Unixtime).UTC().Format(DATETIME_FORMAT10)
+		}
+		event.Matches[i] = em
+	}
+	encoder := json.NewEncoder(output.Writer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(event); err != nil {
+		output.Stopped = true
+	}
+}
+
+// outputWithContext outputs emitted lines together with lines around them
+// (--before-context, --after-context) like grep. "--" is written between
+// groups of lines which are not contiguous. Lines are contiguous in the order they
+// are processed, because lines of merge command are numbered per file.
+func outputWithContext(output *Output, result *Result) {
+	p := output.Param
+	if !result.NeedToOutput {
+		if output.AfterContext > 0 {
+			output.AfterContext--
+			outputContextLine(output, result)
+			return
+		}
+		if int64(len(output.BeforeContext)) == p.beforeContext {
+			if p.beforeContext == 0 {
+				output.ContextSkipped = true
+				return
+			}
+			output.BeforeContext = output.BeforeContext[1:]
+			output.ContextSkipped = true
+		}
+		output.BeforeContext = append(output.BeforeContext, result)
+		return
+	}
+	for _, before := range output.BeforeContext {
+		outputContextLine(output, before)
+	}
+	output.BeforeContext = nil
+	outputContextLine(output, result)
+	output.AfterContext = p.afterContext
+}
+
+func outputContextLine(output *Output, result *Result) {
+	if output.ContextOutput && output.ContextSkipped {
+		fmt.Fprintln(output.Writer, "--")
+	}
+	outputResult(output, result)
+	output.ContextOutput = true
+	output.ContextSkipped = false
+}
+
+// stopAfterRange stops reading input sorted by unixtime (--sorted) when --stop-after
+// consecutive lines have the first unixtime after --filter-to.
+func stopAfterRange(output *Output, result *Result) {
+	if len(result.Matches) > 0 {
+		if result.Matches[0].Unixtime <= output.Param.filterToMS {
+			output.LinesAfterRange = 0
+		} else {
+			output.LinesAfterRange++
+		}
+	}
+	// lines after the period may be still output as --after-context
+	if output.LinesAfterRange >= output.Param.stopAfter && output.AfterContext == 0 {
+		stop(output, result)
+	}
+}
+
+// stop makes run stop reading input after the line.
+func stop(output *Output, result *Result) {
+	output.Stopped = true
+	output.Summary.StoppedEarly = true
+	output.Summary.StoppedAtLine = result.Index + 1
+}
+
+// outputResult writes an emitted line. It must be called in input order,
+// because the delta and gap of each line depend on the lines emitted before it.
+func outputResult(output *Output, result *Result) {
+	p := output.Param
+	output.Summary.NumberOfEmittedLines++
+	if p.outputFormat == OUTPUT_NDJSON {
+		outputEvent(output, result)
+		return
+	}
+	if len(result.Matches) == 0 {
+		if p.markFlag {
+			fmt.Fprint(output.Writer, markText(result, p))
+		}
+		if p.delta != "" {
+			fmt.Fprint(output.Writer, "\t")
+		}
+		if _, err := fmt.Fprintln(output.Writer, lineText(result, p)); err != nil {
+			// the error is returned again when output is flushed at the end
+			output.Stopped = true
+		}
+		return
+	}
+
+	unixtime := result.Matches[0].Unixtime
+	if output.FirstUnixtime == 0 {
+		output.FirstUnixtime = unixtime
+		output.PrevUnixtime = unixtime
+	}
+	elapsed := time.Duration(unixtime-output.PrevUnixtime) * time.Millisecond
+	if p.gapThreshold > 0 && elapsed > p.gapThreshold {
+		fmt.Fprintf(output.Writer, "----- gap: %s -----\n", elapsed)
+	}
+	if p.delta == DELTA_FIRST {
+		elapsed = time.Duration(unixtime-output.FirstUnixtime) * time.Millisecond
+	}
+	if p.markFlag {
+		fmt.Fprint(output.Writer, markText(result, p))
+	}
+	if p.delta != "" {
+		if elapsed >= 0 {
+			fmt.Fprint(output.Writer, "+")
+		}
+		fmt.Fprintf(output.Writer, "%s\t", elapsed)
+	}
+	if _, err := fmt.Fprintln(output.Writer, lineText(result, p)); err != nil {
+		output.Stopped = true
+	}
+	output.PrevUnixtime = unixtime
+}
+
+// splitWriter writes lines to files of time buckets (--split-by). The file of
+// a line is decided by its first unixtime, and lines without unixtime are written
+// to the file of the previous line. Up to --max-open-files files are kept open,
+// and the least recently used one is closed when another file is needed.
+// A file whose name ends with .gz is compressed with gzip.
+type splitWriter struct {
+	param      *Parameter
+	files      map[string]*splitFile
+	lru        *list.List // front is the most recently used
+	created    map[string]bool
+	current    *splitFile
+	bucket     int64
+	bucketName string
+	err        error
+}
+
+type splitFile struct {
+	name   string
+	file   *os.File
+	gzip   *gzip.Writer
+	writer *bufio.Writer
+	elem   *list.Element
+}
+
+// SplitName is the data of --name-template.
+type SplitName struct {
+	Date string
+	Time time.Time
+}
+
+func newSplitWriter(p *Parameter) *splitWriter {
+	return &splitWriter{
+		param:   p,
+		files:   map[string]*splitFile{},
+		lru:     list.New(),
+		created: map[string]bool{},
+		bucket:  -1,
+	}
+}
+
+// selectFile makes the following writes go to the file of the line.
+// The file is opened when the line is actually written.
+func (sw *splitWriter) selectFile(result *Result) {
+	if len(result.Matches) == 0 {
+		return
+	}
+	t := time.UnixMilli(result.Matches[0].Unixtime).UTC()
+	if sw.param.splitBy == SPLIT_HOUR {
+		t = t.Truncate(time.Hour)
+	} else {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if t.UnixMilli() != sw.bucket {
+		sw.bucket = t.UnixMilli()
+		date := t.Format("2006-01-02")
+		if sw.param.splitBy == SPLIT_HOUR {
+			date = t.Format("2006-01-02T15")
+		}
+		sw.bucketName = sw.fileName(SplitName{Date: date, Time: t})
+	}
+}
+
+func (sw *splitWriter) fileName(data SplitName) string {
+	var buf bytes.Buffer
+	if err := sw.param.nameTemplate.Execute(&buf, data); err != nil && sw.err == nil {
+		sw.err = fmt.Errorf("invalid --name-template: %v", err)
+	}
+	return buf.String()
+}
+
+// open returns the file of the name. A file is truncated when it is opened
+// for the first time, and appended when it is opened again after closed.
+func (sw *splitWriter) open(name string) *splitFile {
+	if f, ok := sw.files[name]; ok {
+		sw.lru.MoveToFront(f.elem)
+		return f
+	}
+	if sw.lru.Len() >= sw.param.maxOpenFiles {
+		sw.closeFile(sw.lru.Back().Value.(*splitFile))
+	}
+	f := &splitFile{name: name}
+	path := filepath.Join(sw.param.outputDir, name)
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !sw.created[name] {
+		flag |= os.O_TRUNC
+		sw.created[name] = true
+	}
+	var w io.Writer = io.Discard
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		f.file, err = os.OpenFile(path, flag, 0644)
+	}
+	if err != nil {
+		if sw.err == nil {
+			sw.err = err
+		}
+	} else if strings.HasSuffix(name, ".gz") {
+		// a file opened again is appended as another gzip member
+		f.gzip = gzip.NewWriter(f.file)
+		w = f.gzip
+	} else {
+		w = f.file
+	}
+	f.writer = bufio.NewWriterSize(w, SPLIT_BUFFER_SIZE)
+	f.elem = sw.lru.PushFront(f)
+	sw.files[name] = f
+	return f
+}
+
+func (sw *splitWriter) closeFile(f *splitFile) {
+	err := f.writer.Flush()
+	if f.gzip != nil {
+		if gzErr := f.gzip.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if f.file != nil {
+		if closeErr := f.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil && sw.err == nil {
+		sw.err = err
+	}
+	sw.lru.Remove(f.elem)
+	delete(sw.files, f.name)
+	if sw.current == f {
+		sw.current = nil
+	}
+}
+
+func (sw *splitWriter) Write(b []byte) (int, error) {
+	if sw.bucketName == "" {
+		sw.bucketName = sw.fileName(SplitName{Date: UNTIMED_BUCKET})
+	}
+	if sw.current == nil || sw.current.name != sw.bucketName {
+		sw.current = sw.open(sw.bucketName)
+	}
+	return sw.current.writer.Write(b)
+}
+
+// Close closes all files, and returns the first error occurred while writing.
+func (sw *splitWriter) Close() error {
+	for sw.lru.Len() > 0 {
+		sw.closeFile(sw.lru.Back().Value.(*splitFile))
+	}
+	return sw.err
+}
+
+// detectGaps records intervals longer than --gaps in which no unixtime appeared.
+// It must be called in input order. A unixtime older than the newest one so far
+// never closes a gap, so that slightly disordered lines do not produce false gaps.
+func detectGaps(s *Summary, result *Result, p *Parameter) {
+	line := result.Index + 1
+	for _, m := range result.Matches {
+		unixtime := m.Unixtime
+		if s.gapsNewestUnixtime > 0 && unixtime-s.gapsNewestUnixtime > p.gapsThreshold.Milliseconds() {
+			s.Gaps.Gaps = append(s.Gaps.Gaps, &Gap{
+				Start:     time.Unix(0, s.gapsNewestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT13),
+				End:       time.Unix(0, unixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT13),
+				Duration:  (time.Duration(unixtime-s.gapsNewestUnixtime) * time.Millisecond).String(),
+				StartLine: s.gapsNewestLine,
+				EndLine:   line,
+			})
+		}
+		if s.gapsNewestUnixtime < unixtime {
+			s.gapsNewestUnixtime = unixtime
+			s.gapsNewestLine = line
+		}
+	}
+}
+
+// checkOrder counts unixtime going backwards more than --order-tolerance.
+// It must be called in input order. With --check-order line, the first unixtime of
+// each line is compared with that of the previous line. With --check-order key,
+// each unixtime is compared with the previous one of the same key (see keyScanner).
+func checkOrder(s *Summary, result *Result, p *Parameter) {
+	if s.orderPrevUnixtime == nil {
+		s.orderPrevUnixtime = map[string]int64{}
+	}
+	matches := result.Matches
+	if p.orderMode == ORDER_LINE && len(matches) > 0 {
+		matches = matches[:1]
+	}
+	line := result.Index + 1
+	outOfOrder := false
+	for _, m := range matches {
+		key := m.Key
+		if p.orderMode == ORDER_LINE {
+			// keys are also set for summary, but lines are compared regardless of them
+			key = ""
+		}
+		prev, ok := s.orderPrevUnixtime[key]
+		s.orderPrevUnixtime[key] = m.Unixtime
+		regression := prev - m.Unixtime
+		if !ok || regression <= p.orderToleranceMS {
+			continue
+		}
+		r := s.OutOfOrder
+		r.NumberOfOutOfOrder++
+		if r.worstRegressionMS < regression {
+			r.worstRegressionMS = regression
+			r.WorstRegression = (time.Duration(regression) * time.Millisecond).String()
+			r.WorstRegressionLine = line
+		}
+		if !outOfOrder && len(r.OutOfOrderLines) < ORDER_MAX_LINES {
+			r.OutOfOrderLines = append(r.OutOfOrderLines, line)
+		}
+		outOfOrder = true
+	}
+}
+
+// keyScanner returns the keys of unixtime in a line, which are used for statistics per key.
+// Positions must be given in ascending order. The line is scanned only once, carrying
+// the JSON object stack and the CSV column forward, so that lines with many unixtime
+// are not rescanned from the start for each of them.
+type keyScanner struct {
+	text string
+	// JSON
+	jsonPos    int // next byte to scan
+	stringPos  int // next byte to scan within the string starting at jsonPos
+	stack      []jsonFrame
+	lastString string
+	// CSV
+	csv     bool
+	csvPos  int
+	column  int
+	inQuote bool
+}
+
+type jsonFrame struct {
+	object bool
+	key    string
+}
+
+func newKeyScanner(text string) *keyScanner {
+	return &keyScanner{text: text, csv: strings.Contains(text, ","), column: 1}
+}
+
+// key returns the key of unixtime in [pos, end). The key is the JSON key path, the logfmt
+// key (ex. ts=1720999999), or the column number of comma separated values (ex. "column3")
+// in this order. A column number is given only to unixtime delimited by commas, so that
+// free text containing a comma (ex. "1720999999 started, ok") is not taken as CSV.
+func (k *keyScanner) key(pos, end int) string {
+	if key := k.jsonKeyPath(pos); key != "" {
+		return key
+	}
+	text := k.text
+	head := pos
+	if head > 0 && strings.ContainsRune(`"'`, rune(text[head-1])) {
+		head--
+	}
+	if head > 0 && text[head-1] == '=' {
+		start := head - 1
+		for start > 0 && isLogfmtKeyChar(text[start-1]) {
+			start--
+		}
+		if start < head-1 {
+			return text[start : head-1]
+		}
+	}
+	if k.csv && isCSVField(text, pos, end) {
+		for ; k.csvPos < pos; k.csvPos++ {
+			if text[k.csvPos] == '"' {
+				k.inQuote = !k.inQuote
+			} else if text[k.csvPos] == ',' && !k.inQuote {
+				k.column++
+			}
+		}
+		return "column" + strconv.Itoa(k.column)
+	}
+	return ""
+}
+
+// isCSVField reports whether text[pos:end] is a field of comma separated values,
+// optionally quoted with double quotes.
+func isCSVField(text string, pos, end int) bool {
+	if pos > 0 && end < len(text) && text[pos-1] == '"' && text[end] == '"' {
+		pos--
+		end++
+	}
+	return (pos == 0 || text[pos-1] == ',') && (end == len(text) || text[end] == ',')
+}
+
+func isLogfmtKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// jsonKeyPath returns the dot-joined JSON object keys enclosing pos (ex. "user.created_at").
+// Arrays are transparent, and an empty string is returned outside JSON objects.
+// When pos is within a string, the string is scanned again from where it was left
+// for the next position.
+func (k *keyScanner) jsonKeyPath(pos int) string {
+	text := k.text
+	for k.jsonPos < pos {
+		switch text[k.jsonPos] {
+		case '"':
+			if k.stringPos <= k.jsonPos {
+				k.stringPos = k.jsonPos + 1
+			}
+			for ; k.stringPos < pos && text[k.stringPos] != '"'; k.stringPos++ {
+				if text[k.stringPos] == '\\' {
+					k.stringPos++
+				}
+			}
+			if k.stringPos >= pos {
+				return k.joinKeys()
+			}
+			k.lastString = text[k.jsonPos+1 : k.stringPos]
+			k.jsonPos = k.stringPos
+		case ':':
+			if len(k.stack) > 0 && k.stack[len(k.stack)-1].object {
+				k.stack[len(k.stack)-1].key = k.lastString
+			}
+		case ',':
+			if len(k.stack) > 0 && k.stack[len(k.stack)-1].object {
+				k.stack[len(k.stack)-1].key = ""
+			}
+		case '{':
+			k.stack = append(k.stack, jsonFrame{object: true})
+		case '[':
+			k.stack = append(k.stack, jsonFrame{})
+		case '}', ']':
+			if len(k.stack) > 0 {
+				k.stack = k.stack[:len(k.stack)-1]
+			}
+		}
+		k.jsonPos++
+	}
+	return k.joinKeys()
+}
+
+func (k *keyScanner) joinKeys() string {
+	var keys []string
+	for _, f := range k.stack {
+		if f.object && f.key != "" {
+			keys = append(keys, f.key)
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+func parseFlagSet(args []string) (*FlagVariables, *flag.FlagSet) {
+	fv := FlagVariables{}
+	flagSet := flag.NewFlagSet(APPNAME, flag.ExitOnError)
+	flagSet.Usage = func() {
+		o := flagSet.Output()
+		fmt.Fprintf(o, "---\n")
+		fmt.Fprintf(o, "Usage:\n")
+		fmt.Fprintf(o, "  %s [-s]\n", flagSet.Name())
+		fmt.Fprintf(o, "  %s [-nia] [-f YYYY-mm-ddTHH:MM:SS(.NNN)Z] [-t YYYY-mm-ddTHH:MM:SS(.NNN)Z] [FILE...]\n", flagSet.Name())
+		fmt.Fprintf(o, "  %s merge [--merge-key KEY] [-nia] [-f ...] [-t ...] FILE...\n", flagSet.Name())
+		fmt.Fprintf(o, "  Read STDIN when FILE is not specified or is -\n")
+		fmt.Fprintf(o, "  merge outputs lines of FILEs in order of unixtime, prefixed with FILE name\n")
+		fmt.Fprintf(o, "Options:\n")
+		fmt.Fprintf(o, "  -s (--summary)         Output only summary. (this option cannot be used with {-n,-i,-f,-t} options\n")
+		fmt.Fprintf(o, "  --summary-to [destination of summary {stdout,stderr,FILE}]\n")
+		fmt.Fprintf(o, "                         Output summary together with lines. (this option can be used with any options\n")
+		fmt.Fprintf(o, "  --summary-format [format of summary {json,table,csv,yaml,prom} (default: json)]\n")
+		fmt.Fprintf(o, "  --histogram [bucket width of histogram in summary (ex. 1m, 5m, 1h, auto)]\n")
+		fmt.Fprintf(o, "  --stats                Add events per second/minute and percentiles of intervals between unixtime to summary\n")
+		fmt.Fprintf(o, "                         (memory usage grows with the number of distinct unixtime)\n")
+		fmt.Fprintf(o, "  --gaps [threshold duration (ex. 30s)]\n")
+		fmt.Fprintf(o, "                         List periods without unixtime longer than threshold in summary\n")
+		fmt.Fprintf(o, "  --check-order [unit of order check {line,key}]\n")
+		fmt.Fprintf(o, "                         Report unixtime going backwards per line or per key (JSON key, logfmt key or CSV column) in summary\n")
+		fmt.Fprintf(o, "  --order-tolerance [allowed regression (ex. 1s, default: 0s)]\n")
+		fmt.Fprintf(o, "  --fail-on-disorder     Exit with status %d when out-of-order unixtime is found\n", EXIT_OUT_OF_ORDER)
+		fmt.Fprintf(o, "                         --histogram, --stats, --gaps and --check-order must be used with -s or --summary-to option\n")
+		fmt.Fprintf(o, "  -c (--count)           Output only the number of lines to be output (per FILE when there are several FILEs)\n")
+		fmt.Fprintf(o, "                         (this option cannot be used with {-s,-o,-A,-B,-C,--mark,--mark-column,--split-by,--sort} options and merge command)\n")
+		fmt.Fprintf(o, "  -m (--max-count) [number of lines]\n")
+		fmt.Fprintf(o, "                         Stop reading after this number of lines are output in total (context lines are not counted)\n")
+		fmt.Fprintf(o, "                         With -c, each FILE is counted up to this number\n")
+		fmt.Fprintf(o, "  -q (--quiet)           Output nothing and only return exit status (summary of --summary-to is still output)\n")
+		fmt.Fprintf(o, "                         (this option cannot be used with -s and --split-by options)\n")
+		fmt.Fprintf(o, "  -n (--no-convert)      Output unixtime without converting\n")
+		fmt.Fprintf(o, "  -i (--invert-filter)   Invert and output filtered results\n")
+		fmt.Fprintf(o, "  -a (--annotate)        Output unixtime with converted datetime (this option cannot be used with -n option)\n")
+		fmt.Fprintf(o, "  --annotate-template [template for annotation (default: `%s`)]\n", DEF_ANNOTATE_TMPL)
+		fmt.Fprintf(o, "                         Available fields: {{.Original}} {{.Datetime}} {{.Age}}\n")
+		fmt.Fprintf(o, "  -r (--relative)        Output relative age (ex. 3h12m ago) instead of datetime\n")
+		fmt.Fprintf(o, "  --absolute             Output datetime together with relative age (must be used with -r option)\n")
+		fmt.Fprintf(o, "  --now [base date of relative age (ex. 2024-07-01T00:30:00Z, default: current time)]\n")
+		fmt.Fprintf(o, "  --granularity [smallest unit of relative age {auto,d,h,m,s} (default: auto)]\n")
+		fmt.Fprintf(o, "                         auto outputs only the largest unit\n")
+		fmt.Fprintf(o, "  -f (--filter-from) [filter start date (ex. 2024-07-01T00:30:00Z)]\n")
+		fmt.Fprintf(o, "  -t (--filter-to)   [filter end date   (ex. 2024-07-01T01:00:00Z)]\n")
+		fmt.Fprintf(o, "                         Output only lines containing unixtime within specified period\n")
+		fmt.Fprintf(o, "  -A (--after-context) [number of lines]\n")
+		fmt.Fprintf(o, "                         Output lines after each line within specified period\n")
+		fmt.Fprintf(o, "  -B (--before-context) [number of lines]\n")
+		fmt.Fprintf(o, "                         Output lines before each line within specified period\n")
+		fmt.Fprintf(o, "  -C (--context) [number of lines]\n")
+		fmt.Fprintf(o, "                         Output lines before and after each line within specified period\n")
+		fmt.Fprintf(o, "                         -A, -B and -C must be used with -f or -t option\n")
+		fmt.Fprintf(o, "  --mark                 Output all lines, prefixing lines within specified period\n")
+		fmt.Fprintf(o, "  --mark-prefix [prefix of lines within specified period (default: `>> `)]\n")
+		fmt.Fprintf(o, "  --mark-column          Output all lines with a leading column (IN: within specified period, OUT: out of it, --: no unixtime)\n")
+		fmt.Fprintf(o, "                         --mark and --mark-column must be used with -f or -t option, and cannot be used with {-i,-A,-B,-C,--sorted}\n")
+		fmt.Fprintf(o, "  --record-start [regular expression matching the first line of a record (ex. '^\\d{10} ')]\n")
+		fmt.Fprintf(o, "                         Filter, count and output lines of a record (ex. stack trace) together\n")
+		fmt.Fprintf(o, "  --inherit              Lines without unixtime belong to the record of the previous line with unixtime\n")
+		fmt.Fprintf(o, "                         (this option cannot be used with --record-start option)\n")
+		fmt.Fprintf(o, "  --merge-key [key of unixtime to merge lines by (JSON key, logfmt key or CSV column, ex. ts)]\n")
+		fmt.Fprintf(o, "                         Lines without the key are merged by the first unixtime of each line\n")
+		fmt.Fprintf(o, "  -o (--output) [output format {text,ndjson} (default: text)]\n")
+		fmt.Fprintf(o, "                         ndjson outputs each line as JSON with details of detected unixtime\n")
+		fmt.Fprintf(o, "                         (ndjson cannot be used with --delta, --gap-threshold, -A, -B, -C, --mark and --mark-column options)\n")
+		fmt.Fprintf(o, "  --color [when to color output {auto,always,never} (default: auto)]\n")
+		fmt.Fprintf(o, "                         Color converted datetime red within -f/-t period, otherwise by age\n")
+		fmt.Fprintf(o, "                         (magenta: future, yellow: <1h, green: <1d, cyan: older) and dim lines out of the period\n")
+		fmt.Fprintf(o, "                         auto colors only text output to a terminal without NO_COLOR environment variable\n")
+		fmt.Fprintf(o, "  --split-by [time bucket of output files {hour,day}]\n")
+		fmt.Fprintf(o, "                         Write each line to the file of the bucket of its first unixtime instead of STDOUT\n")
+		fmt.Fprintf(o, "  --output-dir [directory of output files (default: current directory)]\n")
+		fmt.Fprintf(o, "  --name-template [template for name of output files (default: `%s`)]\n", DEF_NAME_TMPL)
+		fmt.Fprintf(o, "                         Available fields: {{.Date}} {{.Time}} (files ending with .gz are compressed)\n")
+		fmt.Fprintf(o, "  --max-open-files [max number of output files kept open (default: %d)]\n", DEF_MAX_OPEN_FILES)
+		fmt.Fprintf(o, "  --sort [order of lines by unixtime {asc,desc}]\n")
+		fmt.Fprintf(o, "                         Sort lines by the first unixtime of each line before output\n")
+		fmt.Fprintf(o, "  --sort-buffer [max bytes sorted in memory (default: %s)]\n", DEF_SORT_BUFFER)
+		fmt.Fprintf(o, "                         Lines beyond it are sorted with temporary files\n")
+		fmt.Fprintf(o, "  --untimed [placement of lines without unixtime in sort {inherit,first,last} (default: %s)]\n", UNTIMED_INHERIT)
+		fmt.Fprintf(o, "                         inherit places them after the previous line with unixtime\n")
+		fmt.Fprintf(o, "  --sorted               Input is sorted by unixtime. Skip to the specified period of FILE by binary search\n")
+		fmt.Fprintf(o, "                         (this option must be used with -f or -t option, and cannot be used with -i option)\n")
+		fmt.Fprintf(o, "  --stop-after [number of lines (default: %d)]\n", DEF_STOP_AFTER)
+		fmt.Fprintf(o, "                         With --sorted, stop reading when this number of consecutive lines are after -t value\n")
+		fmt.Fprintf(o, "  --delta [base of elapsed time {prev,first}]\n")
+		fmt.Fprintf(o, "                         Prefix each line with elapsed time since previous or first unixtime\n")
+		fmt.Fprintf(o, "  --gap-threshold [duration (ex. 5s, 1m30s)]\n")
+		fmt.Fprintf(o, "                         Insert a marker line when elapsed time since previous unixtime exceeds it\n")
+		fmt.Fprintf(o, "  -j (--jobs) [number of workers (default: number of CPUs)]\n")
+		fmt.Fprintf(o, "                         1 converts lines sequentially with the lowest memory usage\n")
+		fmt.Fprintf(o, "  --reorder-lines [max lines waiting to be written in input order (default: %d)]\n", DEF_REORDER_LINES)
+		fmt.Fprintf(o, "  --reorder-bytes [max bytes waiting to be written in input order (default: %s)]\n", DEF_REORDER_BYTES)
+		fmt.Fprintf(o, "  -qt (--quotations) [characters for quotations (default: `\"`)\n")
+		fmt.Fprintf(o, "  -sp (--separators) [characters for separators (default: ` ,\\t`)\n")
+		fmt.Fprintf(o, "                         Set characters to detect unixtime (digits cannot be used)\n")
+		fmt.Fprintf(o, "Exit status:\n")
+		fmt.Fprintf(o, "  0 when any line is matched with -f/-t, or any unixtime is found without them, %d when nothing is matched,\n", EXIT_NO_MATCH)
+		fmt.Fprintf(o, "  %d on usage error, %d when out-of-order unixtime is found with --fail-on-disorder, and %d on I/O error\n", EXIT_USAGE, EXIT_OUT_OF_ORDER, EXIT_IO_ERROR)
+	}
+
+	flagSet.BoolVar(&VersionFlag, "v", false, "")
+	flagSet.StringVar(&fv.filterFrom, "filter-from", "", "")
+	flagSet.StringVar(&fv.filterFrom, "f", "", "")
+	flagSet.StringVar(&fv.filterTo, "filter-to", "", "")
+	flagSet.StringVar(&fv.filterTo, "t", "", "")
+	flagSet.BoolVar(&fv.sortedFlag, "sorted", false, "")
+	flagSet.Int64Var(&fv.stopAfter, "stop-after", 0, "")
+	flagSet.StringVar(&fv.recordStart, "record-start", "", "")
+	flagSet.BoolVar(&fv.inheritFlag, "inherit", false, "")
+	flagSet.StringVar(&fv.mergeKey, "merge-key", "", "")
+	flagSet.StringVar(&fv.sortOrder, "sort", "", "")
+	flagSet.StringVar(&fv.outputFormat, "output", OUTPUT_TEXT, "")
+	flagSet.StringVar(&fv.outputFormat, "o", OUTPUT_TEXT, "")
+	flagSet.StringVar(&fv.color, "color", COLOR_AUTO, "")
+	flagSet.BoolVar(&fv.markFlag, "mark", false, "")
+	flagSet.StringVar(&fv.markPrefix, "mark-prefix", DEF_MARK_PREFIX, "")
+	flagSet.BoolVar(&fv.markColumnFlag, "mark-column", false, "")
+	flagSet.StringVar(&fv.splitBy, "split-by", "", "")
+	flagSet.StringVar(&fv.outputDir, "output-dir", "", "")
+	flagSet.StringVar(&fv.nameTemplate, "name-template", DEF_NAME_TMPL, "")
+	flagSet.IntVar(&fv.maxOpenFiles, "max-open-files", DEF_MAX_OPEN_FILES, "")
+	flagSet.StringVar(&fv.sortBuffer, "sort-buffer", DEF_SORT_BUFFER, "")
+	flagSet.StringVar(&fv.untimed, "untimed", UNTIMED_INHERIT, "")
+	flagSet.Int64Var(&fv.afterContext, "after-context", 0, "")
+	flagSet.Int64Var(&fv.afterContext, "A", 0, "")
+	flagSet.Int64Var(&fv.beforeContext, "before-context", 0, "")
+	flagSet.Int64Var(&fv.beforeContext, "B", 0, "")
+	flagSet.Int64Var(&fv.context, "context", 0, "")
+	flagSet.Int64Var(&fv.context, "C", 0, "")
+	flagSet.BoolVar(&fv.noConvFlag, "no-convert", false, "")
+	flagSet.BoolVar(&fv.noConvFlag, "n", false, "")
+	flagSet.BoolVar(&fv.invertFlag, "invert-filter", false, "")
+	flagSet.BoolVar(&fv.invertFlag, "i", false, "")
+	flagSet.BoolVar(&fv.annotateFlag, "annotate", false, "")
+	flagSet.BoolVar(&fv.annotateFlag, "a", false, "")
+	flagSet.StringVar(&fv.annotateTemplate, "annotate-template", DEF_ANNOTATE_TMPL, "")
+	flagSet.BoolVar(&fv.relativeFlag, "relative", false, "")
+	flagSet.BoolVar(&fv.relativeFlag, "r", false, "")
+	flagSet.BoolVar(&fv.absoluteFlag, "absolute", false, "")
+	flagSet.StringVar(&fv.now, "now", "", "")
+	flagSet.StringVar(&fv.granularity, "granularity", DEF_GRANULARITY, "")
+	flagSet.BoolVar(&fv.summaryFlag, "summary", false, "")
+	flagSet.BoolVar(&fv.summaryFlag, "s", false, "")
+	flagSet.BoolVar(&fv.quietFlag, "quiet", false, "")
+	flagSet.BoolVar(&fv.quietFlag, "q", false, "")
+	flagSet.BoolVar(&fv.countFlag, "count", false, "")
+	flagSet.BoolVar(&fv.countFlag, "c", false, "")
+	flagSet.Int64Var(&fv.maxCount, "max-count", 0, "")
+	flagSet.Int64Var(&fv.maxCount, "m", 0, "")
+	flagSet.StringVar(&fv.summaryTo, "summary-to", "", "")
+	flagSet.StringVar(&fv.summaryFormat, "summary-format", FORMAT_JSON, "")
+	flagSet.StringVar(&fv.histogram, "histogram", "", "")
+	flagSet.BoolVar(&fv.statsFlag, "stats", false, "")
+	flagSet.StringVar(&fv.gaps, "gaps", "", "")
+	flagSet.StringVar(&fv.checkOrder, "check-order", "", "")
+	flagSet.StringVar(&fv.orderTolerance, "order-tolerance", "", "")
+	flagSet.BoolVar(&fv.failOnDisorder, "fail-on-disorder", false, "")
+	flagSet.StringVar(&fv.delta, "delta", "", "")
+	flagSet.StringVar(&fv.gapThreshold, "gap-threshold", "", "")
+	flagSet.IntVar(&fv.jobs, "jobs", runtime.NumCPU(), "")
+	flagSet.IntVar(&fv.jobs, "j", runtime.NumCPU(), "")
+	flagSet.Int64Var(&fv.reorderLines, "reorder-lines", DEF_REORDER_LINES, "")
+	flagSet.StringVar(&fv.reorderBytes, "reorder-bytes", DEF_REORDER_BYTES, "")
+	flagSet.StringVar(&fv.quotations, "quotations", DEF_QUOTATIONS, "")
+	flagSet.StringVar(&fv.quotations, "qt", DEF_QUOTATIONS, "")
+	flagSet.StringVar(&fv.separators, "separators", DEF_SEPARATORS, "")
+	flagSet.StringVar(&fv.separators, "sp", DEF_SEPARATORS, "")
+
+	if len(args) > 0 && args[0] == "merge" {
+		fv.mergeFlag = true
+		args = args[1:]
+	}
+	flagSet.Parse(args)
+	fv.files = flagSet.Args()
+
+	return &fv, flagSet
+}
+
+func validateFlagVariables(fv *FlagVariables) (*Parameter, error) {
+	p := Parameter{noConvFlag: fv.noConvFlag, invertFlag: fv.invertFlag, summaryFlag: fv.summaryFlag, now: time.Now()}
+	p.summaryEnabled = fv.summaryFlag || fv.summaryTo != ""
+
+	if fv.filterFrom != "" {
+		p.filterFlag = true
+		if len(fv.filterFrom) == 20 {
+			fv.filterFrom = strings.Replace(fv.filterFrom, "Z", ".000Z", 1)
+		}
+		unixtime, err := parsedUnixtime(fv.filterFrom)
+		if err != nil {
+			return nil, err
+		}
+		p.filterFromMS = unixtime
+	} else {
+		p.filterFromMS = MIN_UNIXTIME
+	}
+
+	if fv.filterTo != "" {
+		p.filterFlag = true
+		if len(fv.filterTo) == 20 {
+			fv.filterTo = strings.Replace(fv.filterTo, "Z", ".999Z", 1)
+		}
+		unixtime, err := parsedUnixtime(fv.filterTo)
+		if err != nil {
+			return nil, err
+		}
+		p.filterToMS = unixtime
+	} else {
+		p.filterToMS = MAX_UNIXTIME
+	}
+
+	if p.filterToMS < p.filterFromMS {
+		return nil, fmt.Errorf("--filter-from(-f) value cannot be newer than --filter-to(-t) value")
+	}
+
+	if fv.summaryFlag &&
+		(p.filterFlag || fv.invertFlag || fv.noConvFlag) {
+		return nil, fmt.Errorf("--summary(-s) option cannot be used with other options (use --summary-to option to output summary with lines)")
+	}
+
+	switch fv.summaryFormat {
+	case FORMAT_JSON, FORMAT_TABLE, FORMAT_CSV, FORMAT_YAML, FORMAT_PROM:
+		p.summaryFormat = fv.summaryFormat
+	default:
+		return nil, fmt.Errorf("--summary-format value must be one of {json,table,csv,yaml,prom}")
+	}
+	if fv.summaryFormat != FORMAT_JSON && !p.summaryEnabled {
+		return nil, fmt.Errorf("--summary-format option must be used with --summary(-s) or --summary-to option")
+	}
+
+	if fv.histogram != "" {
+		if !p.summaryEnabled {
+			return nil, fmt.Errorf("--histogram option must be used with --summary(-s) or --summary-to option")
+		}
+		p.histogramFlag = true
+		if fv.histogram != HISTOGRAM_AUTO {
+			width, err := time.ParseDuration(fv.histogram)
+			if err != nil || width < time.Second || width%time.Second != 0 {
+				return nil, fmt.Errorf("--histogram value must be auto or a duration in seconds (ex. 1m)")
+			}
+			p.histogramWidthMS = width.Milliseconds()
+		}
+	}
+
+	if fv.statsFlag {
+		if !p.summaryEnabled {
+			return nil, fmt.Errorf("--stats option must be used with --summary(-s) or --summary-to option")
+		}
+		p.statsFlag = true
+	}
+
+	if fv.gaps != "" {
+		if !p.summaryEnabled {
+			return nil, fmt.Errorf("--gaps option must be used with --summary(-s) or --summary-to option")
+		}
+		gapsThreshold, err := time.ParseDuration(fv.gaps)
+		if err != nil || gapsThreshold <= 0 {
+			return nil, fmt.Errorf("--gaps value must be a positive duration (ex. 30s)")
+		}
+		p.gapsThreshold = gapsThreshold
+	}
+
+	if fv.checkOrder != "" {
+		if !p.summaryEnabled {
+			return nil, fmt.Errorf("--check-order option must be used with --summary(-s) or --summary-to option")
+		}
+		if fv.checkOrder != ORDER_LINE && fv.checkOrder != ORDER_KEY {
+			return nil, fmt.Errorf("--check-order value must be one of {line,key}")
+		}
+		p.orderMode = fv.checkOrder
+		p.failOnDisorder = fv.failOnDisorder
+	}
+
+	if fv.orderTolerance != "" {
+		if fv.checkOrder == "" {
+			return nil, fmt.Errorf("--order-tolerance option must be used with --check-order option")
+		}
+		orderTolerance, err := time.ParseDuration(fv.orderTolerance)
+		if err != nil || orderTolerance < 0 {
+			return nil, fmt.Errorf("--order-tolerance value must be a duration (ex. 1s)")
+		}
+		p.orderToleranceMS = orderTolerance.Milliseconds()
+	}
+
+	if fv.failOnDisorder && fv.checkOrder == "" {
+		return nil, fmt.Errorf("--fail-on-disorder option must be used with --check-order option")
+	}
+
+	if fv.invertFlag && !p.filterFlag {
+		return nil, fmt.Errorf("--invert(-i) option must be used with --filter-from(-f) or --filter-to(-t) option")
+	}
+
+	if fv.afterContext < 0 || fv.beforeContext < 0 || fv.context < 0 {
+		return nil, fmt.Errorf("--after-context(-A), --before-context(-B) and --context(-C) values must be 0 or more")
+	}
+	p.afterContext, p.beforeContext = fv.afterContext, fv.beforeContext
+	if p.afterContext == 0 {
+		p.afterContext = fv.context
+	}
+	if p.beforeContext == 0 {
+		p.beforeContext = fv.context
+	}
+	if (p.afterContext > 0 || p.beforeContext > 0) && !p.filterFlag {
+		return nil, fmt.Errorf("--after-context(-A), --before-context(-B) and --context(-C) options must be used with --filter-from(-f) or --filter-to(-t) option")
+	}
+
+	if fv.markFlag || fv.markColumnFlag {
+		if !p.filterFlag {
+			return nil, fmt.Errorf("--mark and --mark-column options must be used with --filter-from(-f) or --filter-to(-t) option")
+		}
+		if fv.invertFlag || p.afterContext > 0 || p.beforeContext > 0 || fv.sortedFlag {
+			return nil, fmt.Errorf("--mark and --mark-column options cannot be used with --invert(-i), --after-context(-A), --before-context(-B), --context(-C) and --sorted options")
+		}
+		p.markFlag = true
+		p.markColumnFlag = fv.markColumnFlag
+		if fv.markFlag {
+			p.markPrefix = fv.markPrefix
+		}
+	}
+
+	if fv.recordStart != "" {
+		if fv.inheritFlag {
+			return nil, fmt.Errorf("--record-start option cannot be used with --inherit option")
+		}
+		recordStart, err := regexp.Compile(fv.recordStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --record-start value: %v", err)
+		}
+		p.recordStart = recordStart
+	}
+	p.recordMode = fv.recordStart != "" || fv.inheritFlag
+
+	if fv.mergeFlag {
+		if len(fv.files) == 0 {
+			return nil, fmt.Errorf("merge command requires FILE arguments")
+		}
+		p.mergeFlag = true
+		p.mergeKey = fv.mergeKey
+	} else if fv.mergeKey != "" {
+		return nil, fmt.Errorf("--merge-key option must be used with merge command")
+	}
+
+	switch fv.sortOrder {
+	case "", SORT_ASC, SORT_DESC:
+		p.sortOrder = fv.sortOrder
+	default:
+		return nil, fmt.Errorf("--sort value must be one of {asc,desc}")
+	}
+	if p.sortOrder != "" && p.mergeFlag {
+		return nil, fmt.Errorf("--sort option cannot be used with merge command")
+	}
+	sortBuffer, err := parsedSize(fv.sortBuffer)
+	if err != nil || sortBuffer < 1 {
+		return nil, fmt.Errorf("--sort-buffer value must be a positive size (ex. 64M)")
+	}
+	p.sortBuffer = sortBuffer
+	switch fv.untimed {
+	case UNTIMED_INHERIT, UNTIMED_FIRST, UNTIMED_LAST:
+		p.untimed = fv.untimed
+	default:
+		return nil, fmt.Errorf("--untimed value must be one of {inherit,first,last}")
+	}
+
+	switch fv.outputFormat {
+	case OUTPUT_TEXT:
+	case OUTPUT_NDJSON:
+		if fv.delta != "" || fv.gapThreshold != "" || p.afterContext > 0 || p.beforeContext > 0 || p.markFlag {
+			return nil, fmt.Errorf("--output(-o) ndjson cannot be used with --delta, --gap-threshold, --after-context(-A), --before-context(-B), --context(-C), --mark and --mark-column options")
+		}
+	default:
+		return nil, fmt.Errorf("--output(-o) value must be one of {text,ndjson}")
+	}
+	p.outputFormat = fv.outputFormat
+
+	if fv.splitBy != "" {
+		if fv.splitBy != SPLIT_HOUR && fv.splitBy != SPLIT_DAY {
+			return nil, fmt.Errorf("--split-by value must be one of {hour,day}")
+		}
+		tmpl, err := template.New("name").Parse(fv.nameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --name-template: %v", err)
+		}
+		if fv.maxOpenFiles < 1 {
+			return nil, fmt.Errorf("--max-open-files value must be 1 or more")
+		}
+		p.splitBy = fv.splitBy
+		p.outputDir = fv.outputDir
+		p.nameTemplate = tmpl
+		p.maxOpenFiles = fv.maxOpenFiles
+	} else if fv.outputDir != "" {
+		return nil, fmt.Errorf("--output-dir option must be used with --split-by option")
+	}
+
+	if fv.quietFlag {
+		if fv.summaryFlag || fv.splitBy != "" {
+			return nil, fmt.Errorf("--quiet(-q) option cannot be used with --summary(-s) and --split-by options")
+		}
+		p.quietFlag = true
+	}
+
+	if fv.countFlag {
+		if fv.summaryFlag || p.outputFormat != OUTPUT_TEXT || p.afterContext > 0 || p.beforeContext > 0 ||
+			p.markFlag || p.splitBy != "" || p.sortOrder != "" || p.mergeFlag {
+			return nil, fmt.Errorf("--count(-c) option cannot be used with --summary(-s), --output(-o) ndjson, --after-context(-A), --before-context(-B), --context(-C), --mark, --mark-column, --split-by and --sort options and merge command")
+		}
+		p.countFlag = true
+	}
+	if fv.maxCount != 0 {
+		if fv.maxCount < 1 {
+			return nil, fmt.Errorf("--max-count(-m) value must be 1 or more")
+		}
+		if fv.summaryFlag {
+			return nil, fmt.Errorf("--max-count(-m) option cannot be used with --summary(-s) option")
+		}
+		p.maxCount = fv.maxCount
+	}
+
+	switch fv.color {
+	case COLOR_AUTO:
+		p.colorFlag = p.outputFormat == OUTPUT_TEXT && p.splitBy == "" &&
+			os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	case COLOR_ALWAYS:
+		if p.outputFormat != OUTPUT_TEXT {
+			return nil, fmt.Errorf("--color always cannot be used with --output(-o) ndjson")
+		}
+		p.colorFlag = true
+	case COLOR_NEVER:
+	default:
+		return nil, fmt.Errorf("--color value must be one of {auto,always,never}")
+	}
+
+	if fv.sortedFlag {
+		if !p.filterFlag {
+			return nil, fmt.Errorf("--sorted option must be used with --filter-from(-f) or --filter-to(-t) option")
+		}
+		if fv.invertFlag {
+			return nil, fmt.Errorf("--sorted option cannot be used with --invert(-i) option")
+		}
+		p.sortedFlag = true
+		if fv.filterTo != "" {
+			p.stopAfter = DEF_STOP_AFTER
+		}
+	}
+	if fv.stopAfter != 0 {
+		if !fv.sortedFlag {
+			return nil, fmt.Errorf("--stop-after option must be used with --sorted option")
+		}
+		if fv.stopAfter < 1 {
+			return nil, fmt.Errorf("--stop-after value must be 1 or more")
+		}
+		if fv.filterTo != "" {
+			p.stopAfter = fv.stopAfter
+		}
+	}
+
+	if fv.now != "" {
+		if len(fv.now) == 20 {
+			fv.now = strings.Replace(fv.now, "Z", ".000Z", 1)
+		}
+		unixtime, err := parsedUnixtime(fv.now)
+		if err != nil {
+			return nil, err
+		}
+		p.now = time.Unix(0, unixtime*int64(time.Millisecond))
+	}
+
+	granularity, err := parsedGranularity(fv.granularity)
+	if err != nil {
+		return nil, err
+	}
+	p.granularity = granularity
+
+	if fv.delta != "" && fv.delta != DELTA_PREV && fv.delta != DELTA_FIRST {
+		return nil, fmt.Errorf("--delta value must be one of {prev,first}")
+	}
+	p.delta = fv.delta
+
+	if fv.gapThreshold != "" {
+		gapThreshold, err := time.ParseDuration(fv.gapThreshold)
+		if err != nil || gapThreshold <= 0 {
+			return nil, fmt.Errorf("--gap-threshold value must be a positive duration (ex. 5s)")
+		}
+		p.gapThreshold = gapThreshold
+	}
+
+	if fv.absoluteFlag && !fv.relativeFlag {
+		return nil, fmt.Errorf("--absolute option must be used with --relative(-r) option")
+	}
+
+	if fv.annotateFlag && fv.relativeFlag {
+		return nil, fmt.Errorf("--annotate(-a) option cannot be used with --relative(-r) option")
+	}
+
+	if fv.annotateFlag || fv.relativeFlag {
+		if fv.noConvFlag {
+			return nil, fmt.Errorf("--annotate(-a) and --relative(-r) options cannot be used with --no-convert(-n) option")
+		}
+		tmplStr := fv.annotateTemplate
+		if fv.relativeFlag {
+			tmplStr = RELATIVE_TMPL
+			if fv.absoluteFlag {
+				tmplStr = ABS_RELATIVE_TMPL
+			}
+		}
+		tmpl, err := template.New("annotate").Parse(tmplStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --annotate-template: %v", err)
+		}
+		p.annotateTemplate = tmpl
+	}
+
+	if fv.jobs < 1 {
+		return nil, fmt.Errorf("--jobs(-j) value must be 1 or more")
+	}
+	p.jobs = fv.jobs
+	if fv.reorderLines < 1 {
+		return nil, fmt.Errorf("--reorder-lines value must be 1 or more")
+	}
+	p.reorderLines = fv.reorderLines
+	reorderBytes, err := parsedSize(fv.reorderBytes)
+	if err != nil || reorderBytes < 1 {
+		return nil, fmt.Errorf("--reorder-bytes value must be a positive size (ex. 64M)")
+	}
+	p.reorderBytes = reorderBytes
+
+	detector, err := newDetector(fv.quotations, fv.separators)
+	if err != nil {
+		return nil, err
+	}
+	p.detector = detector
+
+	return &p, nil
+}
+
+func newDetector(quotations, separators string) (*Detector, error) {
+	d := &Detector{}
+	var err error
+	if len(separators) > 0 {
+		if d.separators, err = newCharSet(separators); err != nil {
+			return nil, fmt.Errorf("invalid --separators(-sp) value: %v", err)
+		}
+	}
+	if len(quotations) > 0 {
+		if d.quotations, err = newCharSet(quotations); err != nil {
+			return nil, fmt.Errorf("invalid --quotations(-qt) value: %v", err)
+		}
+	}
+	return d, nil
+}
+
+// newCharSet interprets chars as a regexp character class (ex. ` ,\t`).
+// The membership of ASCII characters is precomputed, and the regexp is used only for others.
+// Digits are rejected, because a unixtime is detected as a whole run of digits.
+func newCharSet(chars string) (*charSet, error) {
+	re, err := regexp.Compile(`^[` + chars + `]$`)
+	if err != nil {
+		return nil, err
+	}
+	cs := &charSet{regexp: re}
+	for c := 0; c < utf8.RuneSelf; c++ {
+		cs.ascii[c] = re.MatchString(string(rune(c)))
+		if cs.ascii[c] && isDigit(byte(c)) {
+			return nil, fmt.Errorf("digits cannot be used")
+		}
+	}
+	return cs, nil
+}
+
+func (cs *charSet) contains(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r >= 0 && cs.ascii[r]
+	}
+	return cs.regexp.MatchString(string(r))
+}
+
+// next returns the first unixtime found at or after offset. A unixtime is a run of
+// 10 or 13 digits starting with 1 or 2, and is detected by the first matching rule of
+//   - separator: surrounded by separators or the start/end of the line
+//   - quotation: surrounded by quotations
+//   - json: a JSON number value (ex. "key": 1720999999,)
+func (d *Detector) next(text string, offset int) *ReplaceInfo {
+	for i := offset; i < len(text); {
+		if !isDigit(text[i]) {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(text) && isDigit(text[j]) {
+			j++
+		}
+		if (j-i == 10 || j-i == 13) && (text[i] == '1' || text[i] == '2') {
+			if ri := d.detect(text, i, j); ri != nil {
+				return ri
+			}
+		}
+		i = j
+	}
+	return nil
+}
+
+func (d *Detector) detect(text string, start, end int) *ReplaceInfo {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	ri := &ReplaceInfo{
+		UnixtimeStr: text[start:end],
+		StartIndex:  start,
+		EndIndex:    end,
+		TimeFormat:  DATETIME_FORMAT10,
+	}
+	if end-start == 13 {
+		ri.TimeFormat = DATETIME_FORMAT13
+	}
+	switch {
+	case d.separators != nil &&
+		(start == 0 || d.separators.contains(before)) &&
+		(end == len(text) || d.separators.contains(after)):
+		ri.Type = TYPE_SP
+	case d.quotations != nil && start > 0 && end < len(text) &&
+		d.quotations.contains(before) && d.quotations.contains(after):
+		ri.Type = TYPE_QT
+		ri.NeedEscape = before == '"'
+	case isJSONNumberValue(text, start, end):
+		ri.Type = TYPE_JSON
+		ri.NeedQuote = true
+		ri.NeedEscape = true
+	default:
+		return nil
+	}
+	return ri
+}
+
+// isJSONNumberValue reports whether text[start:end] follows `" *: *` and is followed by ` *[,}]` or the end.
+func isJSONNumberValue(text string, start, end int) bool {
+	i := start - 1
+	for i >= 0 && text[i] == ' ' {
+		i--
+	}
+	if i < 0 || text[i] != ':' {
+		return false
+	}
+	for i--; i >= 0 && text[i] == ' '; i-- {
+	}
+	if i < 0 || text[i] != '"' {
+		return false
+	}
+	j := end
+	for j < len(text) && text[j] == ' ' {
+		j++
+	}
+	return j == len(text) || text[j] == ',' || text[j] == '}'
+}
+
+// firstUnixtime returns the first unixtime in text in milliseconds.
+func firstUnixtime(text string, p *Parameter) (int64, bool) {
+	ri := p.detector.next(text, 0)
+	if ri == nil {
+		return 0, false
+	}
+	unixtime, _ := strconv.ParseInt(ri.UnixtimeStr, 10, 64)
+	if len(ri.UnixtimeStr) == 10 {
+		unixtime *= 1000
+	}
+	return unixtime, true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func outputSummary(w io.Writer, s *Summary, p *Parameter) error {
+	filterCommandExample := APPNAME
+	if s.OldestUnixtime > 0 {
+		s.OldestDatetime = time.Unix(0, s.OldestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+		filterCommandExample += " -f " + s.OldestDatetime
+	}
+	if s.NewestUnixtime > 0 {
+		s.NewestDatetime = time.Unix(0, s.NewestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+		filterCommandExample += " -t " + s.NewestDatetime
+	}
+	if s.OldestUnixtime > 0 || s.NewestUnixtime > 0 {
+		s.FilterCommandExample = filterCommandExample
+	}
+	if s.MatchedOldestUnixtime > 0 {
+		s.MatchedOldestDatetime = time.Unix(0, s.MatchedOldestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+	}
+	if s.MatchedNewestUnixtime > 0 {
+		s.MatchedNewestDatetime = time.Unix(0, s.MatchedNewestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+	}
+	if p.histogramFlag {
+		s.Histogram = buildHistogram(s, p)
+	}
+	buildStatistics(s)
+
+	var err error
+	switch p.summaryFormat {
+	case FORMAT_TABLE:
+		err = writeSummaryTable(w, s)
+	case FORMAT_CSV:
+		err = writeSummaryCSV(w, s)
+	case FORMAT_YAML:
+		err = writeSummaryYAML(w, s)
+	case FORMAT_PROM:
+		err = writeSummaryProm(w, s)
+	default:
+		var jsonOutput []byte
+		if jsonOutput, err = jsonMarshalIndent(s); err == nil {
+			_, err = w.Write(jsonOutput)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if p.summaryFormat == FORMAT_JSON || p.summaryFormat == FORMAT_TABLE {
+		if f, ok := w.(nopCloser); ok && s.Histogram != nil && isTerminal(f.File) {
+			_, err = fmt.Fprint(w, renderHistogram(s.Histogram))
+		}
+	}
+	return err
+}
+
+// orderedValue is a JSON value which keeps the order of object keys,
+// so that every summary format lists fields in the same order as JSON.
+type orderedValue struct {
+	Keys   []string
+	Values []*orderedValue
+	Object bool
+	Array  bool
+	Scalar interface{}
 }
 
-type Output struct {
-	mu         *sync.Mutex
-	Index      int64
-	BufResults map[int64]*Result
+func toOrderedValue(v interface{}) (*orderedValue, error) {
+	jsonOutput, err := jsonMarshalIndent(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonOutput))
+	decoder.UseNumber()
+	return decodeOrderedValue(decoder)
 }
 
-type ReplaceInfo struct {
-	UnixtimeStr string
-	StartIndex  int
-	EndIndex    int
-	TimeFormat  string
-	NeedQuote   bool
+func decodeOrderedValue(decoder *json.Decoder) (*orderedValue, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return &orderedValue{Scalar: token}, nil
+	}
+	ov := &orderedValue{Object: delim == '{', Array: delim == '['}
+	for decoder.More() {
+		if ov.Object {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			ov.Keys = append(ov.Keys, key.(string))
+		}
+		value, err := decodeOrderedValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		ov.Values = append(ov.Values, value)
+	}
+	_, err = decoder.Token()
+	return ov, err
 }
 
-func main() {
-	s := &Summary{mu: &sync.Mutex{}}
-	fv, fs := parseFlagSet()
-	if VersionFlag {
-		fmt.Println(Version)
-		os.Exit(0)
+// flatten calls fn for every scalar with its dot-joined path (ex. "Histogram.Buckets.0.Start").
+func (ov *orderedValue) flatten(prefix string, fn func(key, value string)) {
+	if !ov.Object && !ov.Array {
+		fn(prefix, scalarString(ov.Scalar))
+		return
 	}
-	p, err := validateFlagVariables(fv)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		fs.Usage()
-		os.Exit(2)
+	for i, value := range ov.Values {
+		key := strconv.Itoa(i)
+		if ov.Object {
+			key = ov.Keys[i]
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		value.flatten(key, fn)
 	}
+}
 
-	var wg sync.WaitGroup
-	var lineCount int64
-	output := &Output{mu: &sync.Mutex{}, BufResults: map[int64]*Result{}}
-	limiter := make(chan struct{}, runtime.NumCPU())
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := scanner.Text()
-		input := &Input{Index: lineCount, Text: line}
-		limiter <- struct{}{}
-		wg.Add(1)
-		go func(input *Input, output *Output) {
-			defer func() {
-				<-limiter
-				wg.Done()
-			}()
-			result := replaceUnixtimeToDatetime(input, s, p)
-			outputLines(output, result)
-		}(input, output)
-		lineCount++
+func scalarString(scalar interface{}) string {
+	if scalar == nil {
+		return "null"
 	}
-	wg.Wait()
-	outputLines(output, nil)
+	return fmt.Sprint(scalar)
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "error:", err)
-		os.Exit(1)
+func writeSummaryTable(w io.Writer, s *Summary) error {
+	ov, err := toOrderedValue(s)
+	if err != nil {
+		return err
 	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	ov.flatten("", func(key, value string) {
+		fmt.Fprintf(tw, "%s\t%s\n", key, value)
+	})
+	return tw.Flush()
+}
 
-	if fv.summaryFlag {
-		outputSummary(s)
+func writeSummaryCSV(w io.Writer, s *Summary) error {
+	ov, err := toOrderedValue(s)
+	if err != nil {
+		return err
 	}
+	var header, record []string
+	ov.flatten("", func(key, value string) {
+		header = append(header, key)
+		record = append(record, value)
+	})
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.Write(record)
+	cw.Flush()
+	return cw.Error()
 }
 
-func outputLines(output *Output, result *Result) {
-	output.mu.Lock()
-	defer output.mu.Unlock()
-	if result != nil {
-		output.BufResults[result.Index] = result
+func writeSummaryYAML(w io.Writer, s *Summary) error {
+	ov, err := toOrderedValue(s)
+	if err != nil {
+		return err
 	}
-	for len(output.BufResults) != 0 {
-		if tmpRes, ok := output.BufResults[output.Index]; ok {
-			if tmpRes.NeedToOutput {
-				fmt.Println(tmpRes.Text)
-			}
-			delete(output.BufResults, output.Index)
-			output.Index++
+	var sb strings.Builder
+	writeYAMLValue(&sb, ov, "")
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+func writeYAMLValue(sb *strings.Builder, ov *orderedValue, indent string) {
+	for i, value := range ov.Values {
+		if ov.Object {
+			sb.WriteString(indent + yamlKey(ov.Keys[i]) + ":")
 		} else {
-			break
+			sb.WriteString(indent + "-")
+		}
+		switch {
+		case !value.Object && !value.Array:
+			sb.WriteString(" " + yamlScalar(value.Scalar) + "\n")
+		case len(value.Values) == 0 && value.Object:
+			sb.WriteString(" {}\n")
+		case len(value.Values) == 0:
+			sb.WriteString(" []\n")
+		case ov.Array && value.Object:
+			var child strings.Builder
+			writeYAMLValue(&child, value, indent+"  ")
+			sb.WriteString(" " + strings.TrimPrefix(child.String(), indent+"  "))
+		default:
+			sb.WriteString("\n")
+			writeYAMLValue(sb, value, indent+"  ")
 		}
 	}
 }
 
-func parseFlagSet() (*FlagVariables, *flag.FlagSet) {
-	fv := FlagVariables{}
-	flagSet := flag.NewFlagSet(APPNAME, flag.ExitOnError)
-	flagSet.Usage = func() {
-		o := flagSet.Output()
-		fmt.Fprintf(o, "---\n")
-		fmt.Fprintf(o, "Usage:\n")
-		fmt.Fprintf(o, "  %s [-s]\n", flagSet.Name())
-		fmt.Fprintf(o, "  %s [-ni] [-f YYYY-mm-ddTHH:MM:SS(.NNN)Z] [-t YYYY-mm-ddTHH:MM:SS(.NNN)Z]\n", flagSet.Name())
-		fmt.Fprintf(o, "Options:\n")
-		fmt.Fprintf(o, "  -s (--summary)         Output only summary. (this option cannot be used with {-n,-i,-f,-t} options\n")
-		fmt.Fprintf(o, "  -n (--no-convert)      Output unixtime without converting\n")
-		fmt.Fprintf(o, "  -i (--invert-filter)   Invert and output filtered results\n")
-		fmt.Fprintf(o, "  -f (--filter-from) [filter start date (ex. 2024-07-01T00:30:00Z)]\n")
-		fmt.Fprintf(o, "  -t (--filter-to)   [filter end date   (ex. 2024-07-01T01:00:00Z)]\n")
-		fmt.Fprintf(o, "                         Output only lines containing unixtime within specified period\n")
-		fmt.Fprintf(o, "  -qt (--quotations) [characters for quotations (default: `\"`)\n")
-		fmt.Fprintf(o, "  -sp (--separators) [characters for separators (default: ` ,\\t`)\n")
-		fmt.Fprintf(o, "                         Set characters to detect unixtime\n")
+// yamlKey returns key as it is when it is a plain scalar, and quoted otherwise,
+// because keys of Keys come from input (ex. "a b: c").
+func yamlKey(key string) string {
+	switch strings.ToLower(key) {
+	case "", "true", "false", "yes", "no", "on", "off", "null", "~":
+		return yamlScalar(key)
 	}
+	for i, c := range key {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && (c == '.' || c == '-' || '0' <= c && c <= '9')) {
+			return yamlScalar(key)
+		}
+	}
+	return key
+}
 
-	flagSet.BoolVar(&VersionFlag, "v", false, "")
-	flagSet.StringVar(&fv.filterFrom, "filter-from", "", "")
-	flagSet.StringVar(&fv.filterFrom, "f", "", "")
-	flagSet.StringVar(&fv.filterTo, "filter-to", "", "")
-	flagSet.StringVar(&fv.filterTo, "t", "", "")
-	flagSet.BoolVar(&fv.noConvFlag, "no-convert", false, "")
-	flagSet.BoolVar(&fv.noConvFlag, "n", false, "")
-	flagSet.BoolVar(&fv.invertFlag, "invert-filter", false, "")
-	flagSet.BoolVar(&fv.invertFlag, "i", false, "")
-	flagSet.BoolVar(&fv.summaryFlag, "summary", false, "")
-	flagSet.BoolVar(&fv.summaryFlag, "s", false, "")
-	flagSet.StringVar(&fv.quotations, "quotations", DEF_QUOTATIONS, "")
-	flagSet.StringVar(&fv.quotations, "qt", DEF_QUOTATIONS, "")
-	flagSet.StringVar(&fv.separators, "separators", DEF_SEPARATORS, "")
-	flagSet.StringVar(&fv.separators, "sp", DEF_SEPARATORS, "")
-
-	flagSet.Parse(os.Args[1:])
-
-	return &fv, flagSet
+func yamlScalar(scalar interface{}) string {
+	if str, ok := scalar.(string); ok {
+		quoted, _ := json.Marshal(str)
+		return string(quoted)
+	}
+	return scalarString(scalar)
 }
 
-func validateFlagVariables(fv *FlagVariables) (*Parameter, error) {
-	p := Parameter{noConvFlag: fv.noConvFlag, invertFlag: fv.invertFlag, summaryFlag: fv.summaryFlag}
+// writeSummaryProm writes summary in Prometheus text exposition format for textfile collectors.
+func writeSummaryProm(w io.Writer, s *Summary) error {
+	var sb strings.Builder
+	metric := func(name, metricType, help string, value string) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, metricType, name, value)
+	}
+	count := func(name, help string, value int64) {
+		metric(name, "counter", help, strconv.FormatInt(value, 10))
+	}
+	timestamp := func(name, help string, unixtime int64) {
+		if unixtime > 0 {
+			metric(name, "gauge", help, strconv.FormatFloat(float64(unixtime)/1000, 'f', -1, 64))
+		}
+	}
+	count("unix2date_lines_total", "Total number of lines.", s.TotalNumberOfLines)
+	count("unix2date_unixtime_total", "Total number of unixtime.", s.TotalNumberOfUnixtime)
+	count("unix2date_lines_contain_unixtime_total", "Number of lines containing unixtime.", s.NumberOfLinesContainUnixtime)
+	count("unix2date_lines_without_unixtime_total", "Number of lines without unixtime.", s.NumberOfLinesWithoutUnixtime)
+	count("unix2date_matched_lines_total", "Number of lines matched with filter.", s.NumberOfMatchedLines)
+	count("unix2date_emitted_lines_total", "Number of lines written to output.", s.NumberOfEmittedLines)
+	timestamp("unix2date_oldest_timestamp_seconds", "Oldest unixtime in seconds.", s.OldestUnixtime)
+	timestamp("unix2date_newest_timestamp_seconds", "Newest unixtime in seconds.", s.NewestUnixtime)
+	timestamp("unix2date_matched_oldest_timestamp_seconds", "Oldest unixtime of matched lines in seconds.", s.MatchedOldestUnixtime)
+	timestamp("unix2date_matched_newest_timestamp_seconds", "Newest unixtime of matched lines in seconds.", s.MatchedNewestUnixtime)
+	if s.Gaps != nil {
+		count("unix2date_gaps_total", "Number of periods without unixtime longer than threshold.", int64(len(s.Gaps.Gaps)))
+	}
+	if s.OutOfOrder != nil {
+		count("unix2date_out_of_order_total", "Number of unixtime going backwards.", s.OutOfOrder.NumberOfOutOfOrder)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
 
-	if fv.filterFrom != "" {
-		p.filterFlag = true
-		if len(fv.filterFrom) == 20 {
-			fv.filterFrom = strings.Replace(fv.filterFrom, "Z", ".000Z", 1)
+// updateHistogram counts unixtime of a line into buckets. Every unixtime is counted,
+// but the line is counted only in the bucket of its first unixtime.
+// With --histogram auto, buckets are collected per second and merged in buildHistogram.
+func updateHistogram(matches []*Match, s *Summary, p *Parameter) {
+	if len(matches) == 0 {
+		return
+	}
+	widthMS := p.histogramWidthMS
+	if widthMS == 0 {
+		widthMS = int64(time.Second / time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.histogramBuckets == nil {
+		s.histogramBuckets = map[int64]*HistogramBucket{}
+	}
+	for i, m := range matches {
+		start := m.Unixtime - m.Unixtime%widthMS
+		bucket, ok := s.histogramBuckets[start]
+		if !ok {
+			bucket = &HistogramBucket{StartUnixtime: start}
+			s.histogramBuckets[start] = bucket
 		}
-		unixtime, err := parsedUnixtime(fv.filterFrom)
-		if err != nil {
-			return nil, err
+		bucket.NumberOfUnixtime++
+		if i == 0 {
+			bucket.NumberOfLines++
 		}
-		p.filterFromMS = unixtime
-	} else {
-		p.filterFromMS = MIN_UNIXTIME
 	}
+}
 
-	if fv.filterTo != "" {
-		p.filterFlag = true
-		if len(fv.filterTo) == 20 {
-			fv.filterTo = strings.Replace(fv.filterTo, "Z", ".999Z", 1)
+func buildHistogram(s *Summary, p *Parameter) *Histogram {
+	buckets := s.histogramBuckets
+	if len(buckets) == 0 {
+		return nil
+	}
+	widthMS := p.histogramWidthMS
+	if widthMS == 0 {
+		widthMS = autoHistogramWidthMS(s.NewestUnixtime - s.OldestUnixtime)
+		merged := map[int64]*HistogramBucket{}
+		for _, b := range buckets {
+			start := b.StartUnixtime - b.StartUnixtime%widthMS
+			if _, ok := merged[start]; !ok {
+				merged[start] = &HistogramBucket{StartUnixtime: start}
+			}
+			merged[start].NumberOfUnixtime += b.NumberOfUnixtime
+			merged[start].NumberOfLines += b.NumberOfLines
 		}
-		unixtime, err := parsedUnixtime(fv.filterTo)
-		if err != nil {
-			return nil, err
+		buckets = merged
+	}
+
+	var starts []int64
+	first := s.OldestUnixtime - s.OldestUnixtime%widthMS
+	last := s.NewestUnixtime - s.NewestUnixtime%widthMS
+	if (last-first)/widthMS+1 > HISTOGRAM_MAX {
+		for start := range buckets {
+			starts = append(starts, start)
 		}
-		p.filterToMS = unixtime
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
 	} else {
-		p.filterToMS = MAX_UNIXTIME
+		for start := first; start <= last; start += widthMS {
+			starts = append(starts, start)
+		}
 	}
 
-	if p.filterToMS < p.filterFromMS {
-		return nil, fmt.Errorf("--filter-from(-f) value cannot be newer than --filter-to(-t) value")
+	h := &Histogram{BucketWidth: time.Duration(widthMS * int64(time.Millisecond)).String()}
+	for _, start := range starts {
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = &HistogramBucket{StartUnixtime: start}
+		}
+		bucket.Start = time.Unix(0, start*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+		h.Buckets = append(h.Buckets, bucket)
 	}
+	return h
+}
 
-	if fv.summaryFlag &&
-		(p.filterFlag || fv.invertFlag || fv.noConvFlag) {
-		return nil, fmt.Errorf("--summary(-s) option cannot be used with other options")
+// buildStatistics calculates the period of unixtime per key. With --stats, it also
+// calculates the rate of unixtime and the percentiles of intervals between consecutive
+// unixtime in chronological order.
+func buildStatistics(s *Summary) {
+	if s.NewestUnixtime == 0 {
+		return
+	}
+	s.DurationSeconds = float64(s.NewestUnixtime-s.OldestUnixtime) / 1000
+	for _, ks := range s.Keys {
+		ks.OldestDatetime = time.Unix(0, ks.OldestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+		ks.NewestDatetime = time.Unix(0, ks.NewestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
 	}
+	if len(s.unixtimeCounts) == 0 {
+		return
+	}
+	s.NumberOfDistinctUnixtime = int64(len(s.unixtimeCounts))
+	s.EventsPerSecond = eventRate(s, time.Second)
+	s.EventsPerMinute = eventRate(s, time.Minute)
 
-	if fv.invertFlag && !p.filterFlag {
-		return nil, fmt.Errorf("--invert(-i) option must be used with --filter-from(-f) or --filter-to(-t) option")
+	var total int64
+	unixtimes := make([]int64, 0, len(s.unixtimeCounts))
+	for unixtime, count := range s.unixtimeCounts {
+		unixtimes = append(unixtimes, unixtime)
+		total += count
+	}
+	if total < 2 {
+		return
 	}
+	sort.Slice(unixtimes, func(i, j int) bool { return unixtimes[i] < unixtimes[j] })
+	intervals := make([]int64, 0, len(unixtimes))
+	for i := 1; i < len(unixtimes); i++ {
+		intervals = append(intervals, unixtimes[i]-unixtimes[i-1])
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	// the same unixtime appearing n times makes n-1 intervals of zero
+	zeros := total - int64(len(unixtimes))
+	n := zeros + int64(len(intervals))
+	percentile := func(percent int64) int64 {
+		rank := (percent*n + 99) / 100
+		if rank <= zeros {
+			return 0
+		}
+		return intervals[rank-zeros-1]
+	}
+	s.IntervalMilliseconds = &Percentiles{
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: percentile(100),
+	}
+}
 
-	p.replacePatterns = generateReplacePatternList(fv.quotations, fv.separators)
+func eventRate(s *Summary, window time.Duration) *EventRate {
+	windowMS := window.Milliseconds()
+	counts := map[int64]int64{}
+	var total int64
+	for unixtime, count := range s.unixtimeCounts {
+		counts[unixtime-unixtime%windowMS] += count
+		total += count
+	}
+	rate := &EventRate{}
+	var peakStart int64
+	for start, count := range counts {
+		if rate.Peak < count || (rate.Peak == count && start < peakStart) {
+			rate.Peak = count
+			peakStart = start
+		}
+	}
+	numberOfWindows := (s.NewestUnixtime-s.NewestUnixtime%windowMS-(s.OldestUnixtime-s.OldestUnixtime%windowMS))/windowMS + 1
+	rate.Mean = float64(total) / float64(numberOfWindows)
+	rate.PeakWindow = time.Unix(0, peakStart*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
+	return rate
+}
 
-	return &p, nil
+var histogramWidths = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
 }
 
-func generateReplacePatternList(quotations, separators string) []ReplacePattern {
-	var replacePatterns []ReplacePattern
-	if len(separators) > 0 {
-		regexStr := `(?:^|[` + separators + `])` + UNIXTIME_PATTERN + `(?:[` + separators + `]|$)`
-		replacePattern := ReplacePattern{
-			Regexp: regexp.MustCompile(regexStr),
-			Type:   TYPE_SP,
+// autoHistogramWidthMS returns the smallest width that fits the period into HISTOGRAM_BUCKETS buckets.
+func autoHistogramWidthMS(periodMS int64) int64 {
+	for _, width := range histogramWidths {
+		if periodMS/width.Milliseconds() < HISTOGRAM_BUCKETS {
+			return width.Milliseconds()
 		}
-		replacePatterns = append(replacePatterns, replacePattern)
 	}
-	if len(quotations) > 0 {
-		regexStr := `(?:[` + quotations + `])` + UNIXTIME_PATTERN + `(?:[` + quotations + `])`
-		replacePattern := ReplacePattern{
-			Regexp: regexp.MustCompile(regexStr),
-			Type:   TYPE_QT,
+	dayMS := (24 * time.Hour).Milliseconds()
+	return (periodMS/HISTOGRAM_BUCKETS/dayMS + 1) * dayMS
+}
+
+func renderHistogram(h *Histogram) string {
+	var max int64
+	for _, b := range h.Buckets {
+		if max < b.NumberOfUnixtime {
+			max = b.NumberOfUnixtime
 		}
-		replacePatterns = append(replacePatterns, replacePattern)
 	}
-	replacePattern := ReplacePattern{
-		Regexp: regexp.MustCompile(`(?:" *:) *` + UNIXTIME_PATTERN + ` *(?:[,}]|$)`),
-		Type:   TYPE_JSON,
+	var sb strings.Builder
+	for _, b := range h.Buckets {
+		barLen := 0
+		if max > 0 {
+			barLen = int(b.NumberOfUnixtime * HISTOGRAM_BAR_LEN / max)
+		}
+		if barLen == 0 && b.NumberOfUnixtime > 0 {
+			barLen = 1
+		}
+		fmt.Fprintf(&sb, "%s %8d |%s\n", b.Start, b.NumberOfUnixtime, strings.Repeat("#", barLen))
 	}
-	replacePatterns = append(replacePatterns, replacePattern)
-	return replacePatterns
+	return sb.String()
 }
 
-func outputSummary(s *Summary) {
-	filterCommandExample := APPNAME
-	if s.OldestUnixtime > 0 {
-		s.OldestDatetime = time.Unix(0, s.OldestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
-		filterCommandExample += " -f " + s.OldestDatetime
-	}
-	if s.NewestUnixtime > 0 {
-		s.NewestDatetime = time.Unix(0, s.NewestUnixtime*int64(time.Millisecond)).UTC().Format(DATETIME_FORMAT10)
-		filterCommandExample += " -t " + s.NewestDatetime
-	}
-	if s.OldestUnixtime > 0 || s.NewestUnixtime > 0 {
-		s.FilterCommandExample = filterCommandExample
-	}
-	jsonOutput, err := jsonMarshalIndent(s)
-	if err != nil {
-		fmt.Printf("%v\n", err)
-	}
-	fmt.Printf("%s", string(jsonOutput))
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
 
 func parsedUnixtime(datetimeStr string) (int64, error) {
@@ -330,62 +3073,200 @@ func jsonMarshalIndent(t interface{}) ([]byte, error) {
 
 func replaceUnixtimeToDatetime(input *Input, s *Summary, p *Parameter) *Result {
 	text := input.Text
-	orgText := input.Text
+	var sb strings.Builder
 	lineContainUnixtime := false
 	inFilterPeriod := false
-	for {
-		ri := getReplaceInfo(text, p.replacePatterns)
-		if ri == nil {
-			break
-		}
-		atomic.AddInt64(&s.TotalNumberOfUnixtime, 1)
+	var matches []*Match
+	var keys *keyScanner
+	last := 0
+	for ri := p.detector.next(text, 0); ri != nil; ri = p.detector.next(text, ri.EndIndex) {
 		lineContainUnixtime = true
 
 		var targetTime time.Time
-		unixtime, _ := strconv.Atoi(ri.UnixtimeStr)
+		unixtime, _ := strconv.ParseInt(ri.UnixtimeStr, 10, 64)
 		if len(ri.UnixtimeStr) == 10 {
-			targetTime = time.Unix(int64(unixtime), 0)
+			targetTime = time.Unix(unixtime, 0)
 		} else if len(ri.UnixtimeStr) == 13 {
-			targetTime = time.Unix(0, int64(unixtime)*int64(time.Millisecond))
+			targetTime = time.Unix(0, unixtime*int64(time.Millisecond))
 		}
 		datetimeStr := targetTime.UTC().Format(ri.TimeFormat)
+		if p.annotateTemplate != nil {
+			datetimeStr = annotate(ri, datetimeStr, targetTime, p)
+		}
+		unixMilli := targetTime.UnixMilli()
+		m := &Match{Unixtime: unixMilli, Type: ri.Type, Offset: ri.StartIndex, Raw: ri.UnixtimeStr, InWindow: !p.filterFlag}
+		sb.WriteString(text[last:ri.StartIndex])
+		if ri.NeedQuote {
+			sb.WriteByte('"')
+		}
+		m.TextStart = sb.Len()
+		sb.WriteString(datetimeStr)
+		m.TextEnd = sb.Len()
 		if ri.NeedQuote {
-			datetimeStr = `"` + datetimeStr + `"`
+			sb.WriteByte('"')
 		}
-		text = text[:ri.StartIndex] + datetimeStr + text[ri.EndIndex:]
+		last = ri.EndIndex
 
-		unixMilli := targetTime.UnixMilli()
+		if p.orderMode == ORDER_KEY || p.summaryEnabled || p.mergeKey != "" || p.outputFormat == OUTPUT_NDJSON {
+			if keys == nil {
+				keys = newKeyScanner(text)
+			}
+			m.Key = keys.key(ri.StartIndex, ri.StartIndex+len(ri.UnixtimeStr))
+		}
+		matches = append(matches, m)
 		if IsInFilterPeriod(unixMilli, p) {
 			inFilterPeriod = true
+			m.InWindow = true
+		}
+	}
+	orgText := text
+	if lineContainUnixtime {
+		sb.WriteString(text[last:])
+		text = sb.String()
+	}
+
+	matched := !p.filterFlag || (p.invertFlag && !inFilterPeriod) || (!p.invertFlag && inFilterPeriod)
+
+	if p.noConvFlag {
+		// lines not matched are also output as context lines
+		text = orgText
+	}
+	result := &Result{Index: input.Index, Text: text, NeedToOutput: (matched || p.markFlag) && !p.summaryFlag, InFilterPeriod: inFilterPeriod, Matches: matches}
+	if p.outputFormat == OUTPUT_NDJSON {
+		result.Original = orgText
+	}
+	if p.recordStart != nil {
+		result.RecordStart = p.recordStart.MatchString(orgText)
+	} else if p.recordMode {
+		result.RecordStart = lineContainUnixtime
+	}
+	return result
+}
+
+// annotate renders the annotation template for a single unixtime. The result is
+// escaped when it is placed inside a JSON string so that the line stays valid JSON.
+func annotate(ri *ReplaceInfo, datetimeStr string, targetTime time.Time, p *Parameter) string {
+	var buf bytes.Buffer
+	a := Annotation{
+		Original: ri.UnixtimeStr,
+		Datetime: datetimeStr,
+		Age:      relativeAge(targetTime, p.now, p.granularity),
+	}
+	if err := p.annotateTemplate.Execute(&buf, a); err != nil {
+		return datetimeStr
+	}
+	annotated := buf.String()
+	if ri.NeedEscape {
+		escaped, _ := json.Marshal(annotated)
+		annotated = string(escaped[1 : len(escaped)-1])
+	}
+	return annotated
+}
+
+// relativeAge returns the age of t seen from now (ex. "3h12m ago", "in 5d").
+// Units smaller than granularity are truncated, and 0 means only the largest unit.
+func relativeAge(t, now time.Time, granularity time.Duration) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return "in " + humanizeDuration(-d, granularity)
+	}
+	return humanizeDuration(d, granularity) + " ago"
+}
+
+var durationUnits = []struct {
+	unit   time.Duration
+	suffix string
+}{
+	{24 * time.Hour, "d"},
+	{time.Hour, "h"},
+	{time.Minute, "m"},
+	{time.Second, "s"},
+}
+
+// humanizeDuration formats d with units from the largest one in d down to granularity
+// (ex. 2h20m). A duration shorter than granularity is rounded down to 0 of it (ex. 0h).
+// Zero granularity (auto) formats d with only the largest unit.
+func humanizeDuration(d time.Duration, granularity time.Duration) string {
+	var sb strings.Builder
+	smallest := granularity
+	if smallest == 0 {
+		smallest = time.Second
+	}
+	for _, du := range durationUnits {
+		if d < du.unit && sb.Len() == 0 && du.unit > smallest {
+			continue
+		}
+		if sb.Len() > 0 && du.unit < granularity {
+			break
+		}
+		sb.WriteString(strconv.FormatInt(int64(d/du.unit), 10) + du.suffix)
+		d %= du.unit
+		if granularity == 0 {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// parsedSize parses a size in bytes with an optional K, M or G suffix (ex. 64M).
+func parsedSize(size string) (int64, error) {
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(size, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(size, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(size, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
+
+func parsedGranularity(granularity string) (time.Duration, error) {
+	if granularity == DEF_GRANULARITY {
+		return 0, nil
+	}
+	for _, du := range durationUnits {
+		if du.suffix == granularity {
+			return du.unit, nil
 		}
-		updateUnixtimePeriod(unixMilli, s)
 	}
+	return 0, fmt.Errorf("--granularity value must be one of {auto,d,h,m,s}")
+}
 
+// updateSummary counts a line in summary. It is called in input order when the line
+// is processed, so that lines read ahead by workers are not counted after run stopped.
+// Matched lines of a record are decided by the record (see processRecordLine).
+func updateSummary(s *Summary, result *Result, p *Parameter) {
 	atomic.AddInt64(&s.TotalNumberOfLines, 1)
-	if lineContainUnixtime {
+	if len(result.Matches) > 0 {
 		atomic.AddInt64(&s.NumberOfLinesContainUnixtime, 1)
 	} else {
 		atomic.AddInt64(&s.NumberOfLinesWithoutUnixtime, 1)
 	}
-
-	if p.summaryFlag {
-		return &Result{input.Index, text, false}
-	} else if p.filterFlag {
-		if (p.invertFlag && !inFilterPeriod) || (!p.invertFlag && inFilterPeriod) {
-			if p.noConvFlag {
-				return &Result{input.Index, orgText, true}
-			} else {
-				return &Result{input.Index, text, true}
-			}
-		}
-	} else {
-		if p.noConvFlag {
-			return &Result{input.Index, orgText, true}
-		} else {
-			return &Result{input.Index, text, true}
+	atomic.AddInt64(&s.TotalNumberOfUnixtime, int64(len(result.Matches)))
+	for _, m := range result.Matches {
+		updateUnixtimePeriod(m.Unixtime, s)
+	}
+	if p.histogramFlag {
+		updateHistogram(result.Matches, s, p)
+	}
+	if p.summaryEnabled {
+		updateUnixtimeCounts(result.Matches, s, p)
+	}
+	if !p.filterFlag || p.invertFlag != result.InFilterPeriod {
+		atomic.AddInt64(&s.NumberOfMatchedLines, 1)
+		if p.filterFlag {
+			updateMatchedUnixtimePeriod(result.Matches, s)
 		}
 	}
-	return &Result{input.Index, text, false}
 }
 
 func updateUnixtimePeriod(unixtime int64, s *Summary) {
@@ -399,6 +3280,65 @@ func updateUnixtimePeriod(unixtime int64, s *Summary) {
 	}
 }
 
+var detectorNames = map[int]string{
+	TYPE_SP:   "separator",
+	TYPE_QT:   "quotation",
+	TYPE_JSON: "json",
+}
+
+// updateUnixtimeCounts counts unixtime per detector and per key for statistics in summary.
+// Unixtime is also counted per millisecond with --stats.
+func updateUnixtimeCounts(matches []*Match, s *Summary, p *Parameter) {
+	if len(matches) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.NumberOfUnixtimeByDetector == nil {
+		s.NumberOfUnixtimeByDetector = map[string]int64{}
+	}
+	if p.statsFlag && s.unixtimeCounts == nil {
+		s.unixtimeCounts = map[int64]int64{}
+	}
+	for _, m := range matches {
+		if p.statsFlag {
+			s.unixtimeCounts[m.Unixtime]++
+		}
+		s.NumberOfUnixtimeByDetector[detectorNames[m.Type]]++
+		if m.Key == "" {
+			continue
+		}
+		if s.Keys == nil {
+			s.Keys = map[string]*KeyStatistics{}
+		}
+		ks, ok := s.Keys[m.Key]
+		if !ok {
+			ks = &KeyStatistics{OldestUnixtime: m.Unixtime, NewestUnixtime: m.Unixtime}
+			s.Keys[m.Key] = ks
+		}
+		ks.NumberOfUnixtime++
+		if ks.OldestUnixtime > m.Unixtime {
+			ks.OldestUnixtime = m.Unixtime
+		}
+		if ks.NewestUnixtime < m.Unixtime {
+			ks.NewestUnixtime = m.Unixtime
+		}
+	}
+}
+
+func updateMatchedUnixtimePeriod(matches []*Match, s *Summary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range matches {
+		if s.MatchedNewestUnixtime < m.Unixtime {
+			s.MatchedNewestUnixtime = m.Unixtime
+		}
+		if s.MatchedOldestUnixtime > m.Unixtime || s.MatchedOldestUnixtime == 0 {
+			s.MatchedOldestUnixtime = m.Unixtime
+		}
+	}
+}
+
 func IsInFilterPeriod(unixtime int64, p *Parameter) bool {
 	if p.filterFlag {
 		if p.filterFromMS <= unixtime && unixtime <= p.filterToMS {
@@ -407,30 +3347,3 @@ func IsInFilterPeriod(unixtime int64, p *Parameter) bool {
 	}
 	return false
 }
-
-func getReplaceInfo(text string, replacePatterns []ReplacePattern) *ReplaceInfo {
-	for _, rp := range replacePatterns {
-		if textMatch := rp.Regexp.FindStringSubmatchIndex(text); textMatch != nil {
-			startIndex := textMatch[2]
-			endIndex := textMatch[3]
-			unixtimeStr := text[startIndex:endIndex]
-			var timeFormat string
-			if len(unixtimeStr) == 10 {
-				timeFormat = DATETIME_FORMAT10
-			} else if len(unixtimeStr) == 13 {
-				timeFormat = DATETIME_FORMAT13
-			}
-			replaceInfo := &ReplaceInfo{
-				UnixtimeStr: unixtimeStr,
-				StartIndex:  startIndex,
-				EndIndex:    endIndex,
-				TimeFormat:  timeFormat,
-			}
-			if rp.Type == TYPE_JSON {
-				replaceInfo.NeedQuote = true
-			}
-			return replaceInfo
-		}
-	}
-	return nil
-}
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestValidateFlagVariables(t *testing.T) {
@@ -22,6 +34,51 @@ func TestValidateFlagVariables(t *testing.T) {
 		{"-s with -t", &FlagVariables{summaryFlag: true, filterTo: "a"}, false},
 		{"-s with -qt", &FlagVariables{summaryFlag: true, quotations: "a"}, true},
 		{"-s with -sp", &FlagVariables{summaryFlag: true, separators: "a"}, true},
+		{"-sp with digit", &FlagVariables{separators: " 0"}, false},
+		{"-sp with digit class", &FlagVariables{separators: `\d`}, false},
+		{"-qt with digit range", &FlagVariables{quotations: " -~"}, false},
+		{"-a with -n", &FlagVariables{annotateFlag: true, noConvFlag: true}, false},
+		{"-a with invalid template", &FlagVariables{annotateFlag: true, annotateTemplate: "{{.Original"}, false},
+		{"-r with -a", &FlagVariables{relativeFlag: true, annotateFlag: true}, false},
+		{"-r with -n", &FlagVariables{relativeFlag: true, noConvFlag: true}, false},
+		{"--absolute without -r", &FlagVariables{absoluteFlag: true}, false},
+		{"-r with --absolute", &FlagVariables{relativeFlag: true, absoluteFlag: true}, true},
+		{"--now invalid datetime", &FlagVariables{now: "a"}, false},
+		{"--now datetime", &FlagVariables{now: "2024-07-17T00:00:00Z"}, true},
+		{"--granularity invalid unit", &FlagVariables{granularity: "w"}, false},
+		{"--granularity unit", &FlagVariables{granularity: "m"}, true},
+		{"--delta invalid value", &FlagVariables{delta: "next"}, false},
+		{"--delta prev", &FlagVariables{delta: "prev"}, true},
+		{"--gap-threshold invalid duration", &FlagVariables{gapThreshold: "5"}, false},
+		{"--gap-threshold negative duration", &FlagVariables{gapThreshold: "-5s"}, false},
+		{"--gap-threshold duration", &FlagVariables{gapThreshold: "5s"}, true},
+		{"--histogram without -s", &FlagVariables{histogram: "1m"}, false},
+		{"--histogram invalid width", &FlagVariables{summaryFlag: true, histogram: "1"}, false},
+		{"--histogram width under a second", &FlagVariables{summaryFlag: true, histogram: "500ms"}, false},
+		{"--histogram width", &FlagVariables{summaryFlag: true, histogram: "5m"}, true},
+		{"--histogram auto", &FlagVariables{summaryFlag: true, histogram: "auto"}, true},
+		{"--gaps without -s", &FlagVariables{gaps: "30s"}, false},
+		{"--gaps invalid duration", &FlagVariables{summaryFlag: true, gaps: "30"}, false},
+		{"--stats without -s", &FlagVariables{statsFlag: true}, false},
+		{"--stats with -s", &FlagVariables{statsFlag: true, summaryFlag: true}, true},
+		{"--gaps duration", &FlagVariables{summaryFlag: true, gaps: "30s"}, true},
+		{"--check-order without -s", &FlagVariables{checkOrder: "line"}, false},
+		{"--check-order with --summary-to", &FlagVariables{summaryTo: "stderr", checkOrder: "line"}, true},
+		{"--summary-format invalid value", &FlagVariables{summaryFlag: true, summaryFormat: "xml"}, false},
+		{"--summary-format without -s", &FlagVariables{summaryFormat: "csv"}, false},
+		{"--summary-format yaml", &FlagVariables{summaryFlag: true, summaryFormat: "yaml"}, true},
+		{"--jobs negative", &FlagVariables{jobs: -1}, false},
+		{"--jobs 1", &FlagVariables{jobs: 1}, true},
+		{"--reorder-lines negative", &FlagVariables{reorderLines: -1}, false},
+		{"--reorder-bytes invalid size", &FlagVariables{reorderBytes: "1T"}, false},
+		{"--reorder-bytes size", &FlagVariables{reorderBytes: "16K"}, true},
+		{"--summary-to with -f -i -n", &FlagVariables{summaryTo: "stderr", filterFrom: "2014-12-24T00:00:00Z", invertFlag: true, noConvFlag: true}, true},
+		{"--check-order invalid value", &FlagVariables{summaryFlag: true, checkOrder: "file"}, false},
+		{"--check-order key", &FlagVariables{summaryFlag: true, checkOrder: "key"}, true},
+		{"--order-tolerance without --check-order", &FlagVariables{summaryFlag: true, orderTolerance: "1s"}, false},
+		{"--order-tolerance invalid duration", &FlagVariables{summaryFlag: true, checkOrder: "line", orderTolerance: "1"}, false},
+		{"--order-tolerance duration", &FlagVariables{summaryFlag: true, checkOrder: "line", orderTolerance: "1s"}, true},
+		{"--fail-on-disorder without --check-order", &FlagVariables{summaryFlag: true, failOnDisorder: true}, false},
 		{"out-of-range for -f", &FlagVariables{filterFrom: "1950-12-24T00:00:00Z"}, false},
 		{"within-range for -f", &FlagVariables{filterFrom: "2014-12-24T00:00:00Z"}, true},
 		{"out-of-range for -t", &FlagVariables{filterTo: "2080-12-24T00:00:00Z"}, false},
@@ -29,6 +86,55 @@ func TestValidateFlagVariables(t *testing.T) {
 		{"-f newer than -t", &FlagVariables{filterFrom: "2014-12-24T00:00:00Z", filterTo: "2014-12-23T23:59:59Z"}, false},
 		{"-t newer than -f", &FlagVariables{filterTo: "2014-12-24T00:00:00Z", filterFrom: "2014-12-23T23:59:59Z"}, true},
 		{"millisec for -f", &FlagVariables{filterFrom: "2014-12-24T00:00:00.000Z"}, true},
+		{"--sorted without -f or -t", &FlagVariables{sortedFlag: true}, false},
+		{"--sorted with -i", &FlagVariables{sortedFlag: true, filterFrom: "2014-12-24T00:00:00Z", invertFlag: true}, false},
+		{"--stop-after without --sorted", &FlagVariables{stopAfter: 3, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--stop-after negative", &FlagVariables{stopAfter: -1, sortedFlag: true, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"-C without -f or -t", &FlagVariables{context: 3}, false},
+		{"-A negative", &FlagVariables{afterContext: -1, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"-A and -B with -t", &FlagVariables{afterContext: 1, beforeContext: 2, filterTo: "2014-12-24T00:00:00Z"}, true},
+		{"--record-start invalid regexp", &FlagVariables{recordStart: "(["}, false},
+		{"--record-start with --inherit", &FlagVariables{recordStart: "^20", inheritFlag: true}, false},
+		{"--record-start regexp", &FlagVariables{recordStart: `^\d{10} `}, true},
+		{"merge without FILE", &FlagVariables{mergeFlag: true}, false},
+		{"--merge-key without merge", &FlagVariables{mergeKey: "ts"}, false},
+		{"merge with --merge-key", &FlagVariables{mergeFlag: true, mergeKey: "ts", files: []string{"a.log"}}, true},
+		{"--sort invalid order", &FlagVariables{sortOrder: "random"}, false},
+		{"--sort with merge", &FlagVariables{sortOrder: "asc", mergeFlag: true, files: []string{"a.log"}}, false},
+		{"--sort-buffer invalid size", &FlagVariables{sortOrder: "asc", sortBuffer: "0"}, false},
+		{"--untimed invalid placement", &FlagVariables{sortOrder: "asc", untimed: "middle"}, false},
+		{"--sort desc", &FlagVariables{sortOrder: "desc", untimed: "last"}, true},
+		{"--split-by invalid bucket", &FlagVariables{splitBy: "week"}, false},
+		{"--split-by invalid template", &FlagVariables{splitBy: "day", nameTemplate: "{{.Date"}, false},
+		{"--max-open-files negative", &FlagVariables{splitBy: "day", maxOpenFiles: -1}, false},
+		{"--output-dir without --split-by", &FlagVariables{outputDir: "out"}, false},
+		{"--split-by with --output-dir", &FlagVariables{splitBy: "hour", outputDir: "out"}, true},
+		{"--output invalid format", &FlagVariables{outputFormat: "xml"}, false},
+		{"--output ndjson with --delta", &FlagVariables{outputFormat: "ndjson", delta: "prev"}, false},
+		{"--output ndjson with -C", &FlagVariables{outputFormat: "ndjson", context: 1, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--output ndjson", &FlagVariables{outputFormat: "ndjson"}, true},
+		{"--color invalid value", &FlagVariables{color: "yes"}, false},
+		{"--color always with ndjson", &FlagVariables{color: "always", outputFormat: "ndjson"}, false},
+		{"--color always", &FlagVariables{color: "always"}, true},
+		{"--color auto", &FlagVariables{color: "auto"}, true},
+		{"--mark without -f/-t", &FlagVariables{markFlag: true}, false},
+		{"--mark-column without -f/-t", &FlagVariables{markColumnFlag: true}, false},
+		{"--mark with -i", &FlagVariables{markFlag: true, invertFlag: true, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--mark with -C", &FlagVariables{markFlag: true, context: 1, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--mark with --sorted", &FlagVariables{markFlag: true, sortedFlag: true, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--mark with ndjson", &FlagVariables{markFlag: true, outputFormat: "ndjson", filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--count with -C", &FlagVariables{countFlag: true, context: 1, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--count with merge", &FlagVariables{countFlag: true, mergeFlag: true, files: []string{"a.log"}}, false},
+		{"--count with --mark", &FlagVariables{countFlag: true, markFlag: true, filterTo: "2014-12-24T00:00:00Z"}, false},
+		{"--count with -f", &FlagVariables{countFlag: true, filterTo: "2014-12-24T00:00:00Z"}, true},
+		{"--max-count negative", &FlagVariables{maxCount: -1}, false},
+		{"--max-count with -s", &FlagVariables{maxCount: 1, summaryFlag: true}, false},
+		{"--max-count with -C", &FlagVariables{maxCount: 1, context: 1, filterTo: "2014-12-24T00:00:00Z"}, true},
+		{"--quiet with -s", &FlagVariables{quietFlag: true, summaryFlag: true}, false},
+		{"--quiet with --split-by", &FlagVariables{quietFlag: true, splitBy: "day"}, false},
+		{"--quiet with --summary-to", &FlagVariables{quietFlag: true, summaryTo: "stderr"}, true},
+		{"--mark and --mark-column", &FlagVariables{markFlag: true, markColumnFlag: true, filterTo: "2014-12-24T00:00:00Z"}, true},
+		{"--sorted with -t", &FlagVariables{sortedFlag: true, filterTo: "2014-12-24T00:00:00Z"}, true},
 		{"millisec for -t", &FlagVariables{filterFrom: "2014-12-24T00:00:00.999Z"}, true},
 	}
 	for _, tt := range tests {
@@ -46,6 +152,42 @@ func initializeFlagVariables(fv *FlagVariables) {
 	if fv.separators == "" {
 		fv.separators = DEF_SEPARATORS
 	}
+	if fv.granularity == "" {
+		fv.granularity = DEF_GRANULARITY
+	}
+	if fv.summaryFormat == "" {
+		fv.summaryFormat = FORMAT_JSON
+	}
+	if fv.jobs == 0 {
+		fv.jobs = runtime.NumCPU()
+	}
+	if fv.reorderLines == 0 {
+		fv.reorderLines = DEF_REORDER_LINES
+	}
+	if fv.reorderBytes == "" {
+		fv.reorderBytes = DEF_REORDER_BYTES
+	}
+	if fv.sortBuffer == "" {
+		fv.sortBuffer = DEF_SORT_BUFFER
+	}
+	if fv.nameTemplate == "" {
+		fv.nameTemplate = DEF_NAME_TMPL
+	}
+	if fv.maxOpenFiles == 0 {
+		fv.maxOpenFiles = DEF_MAX_OPEN_FILES
+	}
+	if fv.outputFormat == "" {
+		fv.outputFormat = OUTPUT_TEXT
+	}
+	if fv.untimed == "" {
+		fv.untimed = UNTIMED_INHERIT
+	}
+	if fv.color == "" {
+		fv.color = COLOR_NEVER
+	}
+	if fv.markPrefix == "" {
+		fv.markPrefix = DEF_MARK_PREFIX
+	}
 }
 
 func TestReplaceUnixtimeToDatetimeFilterTest(t *testing.T) {
@@ -278,3 +420,1638 @@ func TestReplaceUnixtimeToDatetime(t *testing.T) {
 		}
 	}
 }
+
+func TestReplaceUnixtimeToDatetimeWithAnnotate(t *testing.T) {
+	s := &Summary{mu: &sync.Mutex{}}
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		input  string
+		expect string
+	}{
+		{"default template", &FlagVariables{annotateFlag: true},
+			"1720999999 1720999999321", "1720999999 [2024-07-14T23:33:19Z] 1720999999321 [2024-07-14T23:33:19.321Z]"},
+		{"template with age", &FlagVariables{annotateFlag: true, annotateTemplate: "{{.Original}} ({{.Age}})"},
+			"a 1720999999", "a 1720999999 (2d ago)"},
+		{"future age", &FlagVariables{annotateFlag: true, annotateTemplate: "{{.Datetime}} ({{.Age}})"},
+			"1721433600", "2024-07-20T00:00:00Z (in 3d)"},
+		{"json number goes into string", &FlagVariables{annotateFlag: true},
+			`{"a":1720999999}`, `{"a":"1720999999 [2024-07-14T23:33:19Z]"}`},
+		{"escape in json string", &FlagVariables{annotateFlag: true, annotateTemplate: `{{.Original}} "{{.Datetime}}"`},
+			`{"a":"1720999999"}`, `{"a":"1720999999 \"2024-07-14T23:33:19Z\""}`},
+		{"no escape outside json", &FlagVariables{annotateFlag: true, annotateTemplate: `{{.Original}} "{{.Datetime}}"`},
+			`1720999999`, `1720999999 "2024-07-14T23:33:19Z"`},
+	}
+	for _, tt := range tests {
+		if tt.fv.annotateTemplate == "" {
+			tt.fv.annotateTemplate = DEF_ANNOTATE_TMPL
+		}
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		p.now = time.Date(2024, 7, 17, 0, 0, 0, 0, time.UTC)
+		input := &Input{Index: 0, Text: tt.input}
+		if actual := replaceUnixtimeToDatetime(input, s, p); actual.Text != tt.expect {
+			t.Errorf("[ NG ] => %s\n   input: %v\n  expect: %v\n  actual: %v", tt.name, tt.input, tt.expect, actual.Text)
+		} else {
+			t.Logf("[ OK ] => %s\n   input: %v\n  expect: %v\n  actual: %v", tt.name, tt.input, tt.expect, actual.Text)
+		}
+	}
+}
+
+func TestReplaceUnixtimeToDatetimeWithRelative(t *testing.T) {
+	s := &Summary{mu: &sync.Mutex{}}
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		input  string
+		expect string
+	}{
+		{"auto granularity", &FlagVariables{relativeFlag: true},
+			"1720999999", "2d ago"},
+		{"minute granularity", &FlagVariables{relativeFlag: true, granularity: "m"},
+			"1721166000 1721174400", "2h20m ago 0m ago"},
+		{"second granularity", &FlagVariables{relativeFlag: true, granularity: "s"},
+			"1720999999321", "2d0h26m40s ago"},
+		{"smaller than granularity", &FlagVariables{relativeFlag: true, granularity: "h"},
+			"1721174355", "0h ago"},
+		{"minutes smaller than hour granularity", &FlagVariables{relativeFlag: true, granularity: "h"},
+			"1721173440", "0h ago"},
+		{"days with hour granularity", &FlagVariables{relativeFlag: true, granularity: "h"},
+			"1720999999", "2d0h ago"},
+		{"auto granularity under a second", &FlagVariables{relativeFlag: true},
+			"1721174400000", "0s ago"},
+		{"future", &FlagVariables{relativeFlag: true, granularity: "h"},
+			"1721433600", "in 3d0h"},
+		{"with absolute", &FlagVariables{relativeFlag: true, absoluteFlag: true},
+			"1720999999", "2024-07-14T23:33:19Z (2d ago)"},
+		{"json number", &FlagVariables{relativeFlag: true},
+			`{"a":1720999999}`, `{"a":"2d ago"}`},
+	}
+	for _, tt := range tests {
+		tt.fv.now = "2024-07-17T00:00:00Z"
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		input := &Input{Index: 0, Text: tt.input}
+		if actual := replaceUnixtimeToDatetime(input, s, p); actual.Text != tt.expect {
+			t.Errorf("[ NG ] => %s\n   input: %v\n  expect: %v\n  actual: %v", tt.name, tt.input, tt.expect, actual.Text)
+		} else {
+			t.Logf("[ OK ] => %s\n   input: %v\n  expect: %v\n  actual: %v", tt.name, tt.input, tt.expect, actual.Text)
+		}
+	}
+}
+
+func TestProcessResultWithDelta(t *testing.T) {
+	results := []*Result{
+		{Index: 0, Text: "a", NeedToOutput: true, Matches: matchesOf(1720999999000)},
+		{Index: 1, Text: "b", NeedToOutput: true, Matches: matchesOf(1720999999500, 1720999990000)},
+		{Index: 2, Text: "c", NeedToOutput: true},
+		{Index: 3, Text: "d", NeedToOutput: false, Matches: matchesOf(1720999999600)},
+		{Index: 4, Text: "e", NeedToOutput: true, Matches: matchesOf(1720999998000)},
+		{Index: 5, Text: "f", NeedToOutput: true, Matches: matchesOf(1721000009000)},
+	}
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect string
+	}{
+		{"no delta", &FlagVariables{},
+			"a\nb\nc\ne\nf\n"},
+		{"delta since previous", &FlagVariables{delta: "prev"},
+			"+0s\ta\n+500ms\tb\n\tc\n-1.5s\te\n+11s\tf\n"},
+		{"delta since first", &FlagVariables{delta: "first"},
+			"+0s\ta\n+500ms\tb\n\tc\n-1s\te\n+10s\tf\n"},
+		{"gap threshold", &FlagVariables{gapThreshold: "10s"},
+			"a\nb\nc\ne\n----- gap: 11s -----\nf\n"},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		var buf bytes.Buffer
+		output := &Output{Writer: &buf, Param: p, Summary: &Summary{mu: &sync.Mutex{}}}
+		for _, result := range results {
+			processResult(output, result)
+		}
+		if actual := buf.String(); actual != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, tt.expect, actual)
+		} else {
+			t.Logf("[ OK ] => %s\n  expect: %q\n  actual: %q", tt.name, tt.expect, actual)
+		}
+	}
+}
+
+func TestRunWithContext(t *testing.T) {
+	input := strings.Join([]string{
+		"1720999990 a",
+		"x1",
+		"1720999999 b",
+		"x2",
+		"x3",
+		"1721000100 c",
+		"1721000101 d",
+		"1720999999 e",
+		"x4",
+	}, "\n") + "\n"
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect []string
+	}{
+		{"-A1 -B1", &FlagVariables{afterContext: 1, beforeContext: 1}, []string{"x1", "1720999999 b", "x2", "--", "1721000101 d", "1720999999 e", "x4"}},
+		{"-C2", &FlagVariables{context: 2}, strings.Split(strings.TrimSuffix(input, "\n"), "\n")},
+		{"-B1", &FlagVariables{beforeContext: 1}, []string{"x1", "1720999999 b", "--", "1721000101 d", "1720999999 e"}},
+		{"-A1", &FlagVariables{afterContext: 1}, []string{"1720999999 b", "x2", "--", "1720999999 e", "x4"}},
+		{"-C1", &FlagVariables{context: 1}, []string{"x1", "1720999999 b", "x2", "--", "1721000101 d", "1720999999 e", "x4"}},
+	}
+	for _, tt := range tests {
+		tt.fv.filterFrom = "2024-07-14T23:33:15Z"
+		tt.fv.filterTo = "2024-07-14T23:33:19Z"
+		tt.fv.noConvFlag = true
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		if err := run(strings.NewReader(input), &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		expect := strings.Join(tt.expect, "\n") + "\n"
+		if buf.String() != expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, expect, buf.String())
+		}
+	}
+}
+
+func TestRunWithRecords(t *testing.T) {
+	input := strings.Join([]string{
+		"# header",
+		"1720999990 INFO start",
+		"  at a",
+		"1720999999 ERROR failed",
+		"  at b",
+		"  at c",
+		"1721000100 INFO next",
+		`  "created_at": 1720999999,`,
+	}, "\n") + "\n"
+	tests := []struct {
+		name          string
+		fv            *FlagVariables
+		expect        []string
+		expectRecords RecordReport
+	}{
+		{"--inherit", &FlagVariables{inheritFlag: true},
+			[]string{"1720999999 ERROR failed", "  at b", "  at c", `  "created_at": 1720999999,`}, RecordReport{5, 2}},
+		{"--record-start", &FlagVariables{recordStart: `^\d{10} `},
+			[]string{"1720999999 ERROR failed", "  at b", "  at c", "1721000100 INFO next", `  "created_at": 1720999999,`}, RecordReport{4, 2}},
+		{"--record-start with -i", &FlagVariables{recordStart: `^\d{10} `, invertFlag: true},
+			[]string{"# header", "1720999990 INFO start", "  at a"}, RecordReport{4, 2}},
+		{"record longer than --reorder-lines", &FlagVariables{recordStart: `^\d{10} `, reorderLines: 1},
+			[]string{"1720999999 ERROR failed", "  at b", "  at c"}, RecordReport{4, 1}},
+		{"--record-start with -C", &FlagVariables{recordStart: `^\d{10} `, context: 1},
+			[]string{"  at a", "1720999999 ERROR failed", "  at b", "  at c", "1721000100 INFO next", `  "created_at": 1720999999,`}, RecordReport{4, 2}},
+	}
+	for _, tt := range tests {
+		tt.fv.filterFrom = "2024-07-14T23:33:15Z"
+		tt.fv.filterTo = "2024-07-14T23:33:19Z"
+		tt.fv.noConvFlag = true
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		s := &Summary{mu: &sync.Mutex{}, Records: &RecordReport{}}
+		var buf bytes.Buffer
+		if err := run(strings.NewReader(input), &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		expect := strings.Join(tt.expect, "\n") + "\n"
+		if buf.String() != expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, expect, buf.String())
+		}
+		// context lines are emitted but not matched
+		if *s.Records != tt.expectRecords || (tt.fv.context == 0 && s.NumberOfMatchedLines != int64(len(tt.expect))) {
+			t.Errorf("[ NG ] => %s: records: %+v matched lines: %d", tt.name, *s.Records, s.NumberOfMatchedLines)
+		}
+	}
+}
+
+func TestRunMerge(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	b := filepath.Join(dir, "b.log")
+	os.WriteFile(a, []byte("1720999990 a1\n  a1 continued\n1720999995 a2\n1720999996 a3\n"), 0644)
+	os.WriteFile(b, []byte("b0 no unixtime\n1720999992 b1\n1720999995 b2\n{\"id\":1720999991,\"ts\":1720999999}\n"), 0644)
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect []string
+	}{
+		{"merge", &FlagVariables{},
+			[]string{b + ":b0 no unixtime", a + ":1720999990 a1", a + ":  a1 continued", b + ":1720999992 b1", a + ":1720999995 a2", b + ":1720999995 b2", b + `:{"id":1720999991,"ts":1720999999}`, a + ":1720999996 a3"}},
+		{"--merge-key", &FlagVariables{mergeKey: "ts"},
+			[]string{b + ":b0 no unixtime", a + ":1720999990 a1", a + ":  a1 continued", b + ":1720999992 b1", a + ":1720999995 a2", b + ":1720999995 b2", a + ":1720999996 a3", b + `:{"id":1720999991,"ts":1720999999}`}},
+		{"merge with -f", &FlagVariables{filterFrom: "2024-07-14T23:33:12Z"},
+			[]string{b + ":1720999992 b1", a + ":1720999995 a2", b + ":1720999995 b2", b + `:{"id":1720999991,"ts":1720999999}`, a + ":1720999996 a3"}},
+		{"merge with -B 1", &FlagVariables{filterFrom: "2024-07-14T23:33:15Z", filterTo: "2024-07-14T23:33:15Z", beforeContext: 1},
+			[]string{b + ":1720999992 b1", a + ":1720999995 a2", b + ":1720999995 b2"}},
+		{"merge with --color", &FlagVariables{filterFrom: "2024-07-14T23:33:12Z", color: "always", now: "2024-07-15T00:00:00Z"},
+			[]string{b + ":\x1b[1;31m1720999992\x1b[0m b1", a + ":\x1b[1;31m1720999995\x1b[0m a2", b + ":\x1b[1;31m1720999995\x1b[0m b2",
+				b + `:{"id":` + "\x1b[33m1720999991\x1b[0m" + `,"ts":` + "\x1b[1;31m1720999999\x1b[0m}", a + ":\x1b[1;31m1720999996\x1b[0m a3"}},
+	}
+	for _, tt := range tests {
+		tt.fv.mergeFlag = true
+		tt.fv.files = []string{a, b}
+		tt.fv.noConvFlag = true
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		if err := runMerge(tt.fv.files, &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		expect := strings.Join(tt.expect, "\n") + "\n"
+		if buf.String() != expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, expect, buf.String())
+		}
+	}
+}
+
+func TestRunMergeLineNumbers(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.log")
+	b := filepath.Join(dir, "b.log")
+	os.WriteFile(a, []byte("1720999990 a1\n1720999992 a2\n"), 0644)
+	os.WriteFile(b, []byte("1720999991 b1\n1720999993 b2\n"), 0644)
+	fv := &FlagVariables{mergeFlag: true, files: []string{a, b}, outputFormat: "ndjson"}
+	initializeFlagVariables(fv)
+	p, err := validateFlagVariables(fv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Summary{mu: &sync.Mutex{}}
+	var buf bytes.Buffer
+	if err := runMerge(fv.files, &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+		t.Fatal(err)
+	}
+	// each line is numbered in its own file
+	var actual []string
+	for _, event := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		actual = append(actual, event[:strings.Index(event, `,"Original"`)])
+	}
+	expect := []string{
+		`{"Line":1,"Source":"` + a + `"`,
+		`{"Line":1,"Source":"` + b + `"`,
+		`{"Line":2,"Source":"` + a + `"`,
+		`{"Line":2,"Source":"` + b + `"`,
+	}
+	if strings.Join(actual, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("[ NG ]\n  expect: %v\n  actual: %v", expect, actual)
+	}
+}
+
+func TestSortInputs(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+	os.WriteFile(name, []byte(strings.Join([]string{
+		"header",
+		"1720999995 b",
+		"  b continued",
+		"1720999990 a",
+		"1720999999000 d",
+		"1720999995 c",
+		"  c continued",
+	}, "\n")+"\n"), 0644)
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect []string
+	}{
+		{"asc", &FlagVariables{sortOrder: "asc"},
+			[]string{"header", "1720999990 a", "1720999995 b", "  b continued", "1720999995 c", "  c continued", "1720999999000 d"}},
+		{"desc", &FlagVariables{sortOrder: "desc"},
+			[]string{"header", "1720999999000 d", "1720999995 b", "  b continued", "1720999995 c", "  c continued", "1720999990 a"}},
+		{"untimed first", &FlagVariables{sortOrder: "asc", untimed: "first"},
+			[]string{"header", "  b continued", "  c continued", "1720999990 a", "1720999995 b", "1720999995 c", "1720999999000 d"}},
+		{"untimed last", &FlagVariables{sortOrder: "desc", untimed: "last"},
+			[]string{"1720999999000 d", "1720999995 b", "1720999995 c", "1720999990 a", "header", "  b continued", "  c continued"}},
+		{"sorted with temporary files", &FlagVariables{sortOrder: "asc", sortBuffer: "100"}, // 1 or 2 lines per file
+			[]string{"header", "1720999990 a", "1720999995 b", "  b continued", "1720999995 c", "  c continued", "1720999999000 d"}},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		var buf bytes.Buffer
+		if err := sortInputs([]string{name}, &buf, p); err != nil {
+			t.Fatal(err)
+		}
+		if expect := strings.Join(tt.expect, "\n") + "\n"; buf.String() != expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, expect, buf.String())
+		}
+	}
+}
+
+func TestRunSortedRemovesTemporaryFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	name := filepath.Join(t.TempDir(), "test.log")
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "%d line\n", 1721009999-i)
+	}
+	os.WriteFile(name, []byte(sb.String()), 0644)
+	fv := &FlagVariables{sortOrder: "asc", sortBuffer: "10K", maxCount: 1}
+	initializeFlagVariables(fv)
+	p, err := validateFlagVariables(fv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Summary{mu: &sync.Mutex{}}
+	var buf bytes.Buffer
+	if err := runSorted([]string{name}, &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "2024-07-14T23:33:20Z line\n"; buf.String() != expect {
+		t.Errorf("[ NG ] => expect: %q actual: %q", expect, buf.String())
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) > 0 {
+		t.Errorf("[ NG ] => %d temporary files are left", len(entries))
+	}
+}
+
+func TestRunWithSplitBy(t *testing.T) {
+	dir := t.TempDir()
+	input := "header\n1720999999 a\n  continued\n1721001600 b\n1720999999 c\n"
+	// a file which exists before is truncated
+	os.MkdirAll(filepath.Join(dir, "logs"), 0755)
+	os.WriteFile(filepath.Join(dir, "logs", "2024-07-15T00.log.gz"), []byte("old"), 0644)
+
+	fv := &FlagVariables{splitBy: "hour", outputDir: dir, nameTemplate: "logs/{{.Date}}.log.gz", maxOpenFiles: 1, noConvFlag: true}
+	initializeFlagVariables(fv)
+	p, err := validateFlagVariables(fv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Summary{mu: &sync.Mutex{}}
+	splitter := newSplitWriter(p)
+	if err := run(strings.NewReader(input), &Output{Writer: splitter, Param: p, Summary: s}, s, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := splitter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		expect string
+	}{
+		{"untimed.log.gz", "header\n"},
+		{"2024-07-14T23.log.gz", "1720999999 a\n  continued\n1720999999 c\n"},
+		{"2024-07-15T00.log.gz", "1721001600 b\n"},
+	}
+	for _, tt := range tests {
+		f, err := os.Open(filepath.Join(dir, "logs", tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := io.ReadAll(gz)
+		if err != nil || string(actual) != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q %v", tt.name, tt.expect, actual, err)
+		}
+	}
+}
+
+func TestRunWithNDJSON(t *testing.T) {
+	input := "1720999999 {\"ts\":1721000000123}\nno unixtime\n"
+	fv := &FlagVariables{outputFormat: "ndjson", filterTo: "2024-07-14T23:33:19Z"}
+	initializeFlagVariables(fv)
+	p, err := validateFlagVariables(fv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Summary{mu: &sync.Mutex{}}
+	var buf bytes.Buffer
+	if err := run(strings.NewReader(input), &Output{Writer: &buf, Param: p, Summary: s, Source: "test.log"}, s, p); err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"Line":1,"Source":"test.log","Original":"1720999999 {\"ts\":1721000000123}","Converted":"2024-07-14T23:33:19Z {\"ts\":\"2024-07-14T23:33:20.123Z\"}","Matches":[` +
+		`{"Offset":0,"Raw":"1720999999","Unit":"s","Detector":"separator","Datetime":"2024-07-14T23:33:19Z","EpochMS":1720999999000,"InWindow":true},` +
+		`{"Offset":17,"Raw":"1721000000123","Unit":"ms","Detector":"json","Key":"ts","Datetime":"2024-07-14T23:33:20.123Z","EpochMS":1721000000123,"InWindow":false}]}` + "\n"
+	if buf.String() != expect {
+		t.Errorf("[ NG ]\n  expect: %s\n  actual: %s", expect, buf.String())
+	}
+}
+
+func TestRunWithColor(t *testing.T) {
+	input := "1720999999 {\"ts\":1721000000123}\n1720900000 out of period\nno unixtime\n"
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect string
+	}{
+		{"by age", &FlagVariables{color: "always", now: "2024-07-15T00:00:00Z"},
+			"\x1b[33m2024-07-14T23:33:19Z\x1b[0m {\"ts\":\"\x1b[33m2024-07-14T23:33:20.123Z\x1b[0m\"}\n" +
+				"\x1b[36m2024-07-13T19:46:40Z\x1b[0m out of period\n" +
+				"no unixtime\n"},
+		{"with filter", &FlagVariables{color: "always", now: "2024-07-15T00:00:00Z", filterFrom: "2024-07-14T00:00:00Z", filterTo: "2024-07-14T23:33:19Z"},
+			"\x1b[1;31m2024-07-14T23:33:19Z\x1b[0m {\"ts\":\"\x1b[33m2024-07-14T23:33:20.123Z\x1b[0m\"}\n"},
+		{"with filter and -i", &FlagVariables{color: "always", now: "2024-07-15T00:00:00Z", filterFrom: "2024-07-14T00:00:00Z", filterTo: "2024-07-14T23:33:19Z", invertFlag: true},
+			"\x1b[2m\x1b[36m2024-07-13T19:46:40Z\x1b[0m\x1b[2m out of period\x1b[0m\n" +
+				"\x1b[2mno unixtime\x1b[0m\n"},
+		{"with -n", &FlagVariables{color: "always", now: "2024-07-15T00:00:00Z", noConvFlag: true},
+			"\x1b[33m1720999999\x1b[0m {\"ts\":\x1b[33m1721000000123\x1b[0m}\n" +
+				"\x1b[36m1720900000\x1b[0m out of period\n" +
+				"no unixtime\n"},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		if err := run(strings.NewReader(input), &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, tt.expect, buf.String())
+		} else {
+			t.Logf("[ OK ] => %s", tt.name)
+		}
+	}
+}
+
+func TestRunWithMark(t *testing.T) {
+	input := "1720999999 in\n1720900000 out\nno unixtime\n"
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect string
+	}{
+		{"--mark", &FlagVariables{markFlag: true, filterFrom: "2024-07-14T00:00:00Z"},
+			">> 2024-07-14T23:33:19Z in\n   2024-07-13T19:46:40Z out\n   no unixtime\n"},
+		{"--mark-prefix", &FlagVariables{markFlag: true, markPrefix: "★", filterFrom: "2024-07-14T00:00:00Z"},
+			"★2024-07-14T23:33:19Z in\n 2024-07-13T19:46:40Z out\n no unixtime\n"},
+		{"--mark-column", &FlagVariables{markColumnFlag: true, filterFrom: "2024-07-14T00:00:00Z"},
+			"IN\t2024-07-14T23:33:19Z in\nOUT\t2024-07-13T19:46:40Z out\n--\tno unixtime\n"},
+		{"--mark-column with --inherit", &FlagVariables{markColumnFlag: true, inheritFlag: true, filterFrom: "2024-07-14T00:00:00Z"},
+			"IN\t2024-07-14T23:33:19Z in\nOUT\t2024-07-13T19:46:40Z out\nOUT\tno unixtime\n"},
+		{"--mark with --color", &FlagVariables{markFlag: true, color: "always", now: "2024-07-15T00:00:00Z", filterFrom: "2024-07-14T00:00:00Z"},
+			"\x1b[1;31m>> \x1b[0m\x1b[1;31m2024-07-14T23:33:19Z\x1b[0m in\n" +
+				"   \x1b[2m\x1b[36m2024-07-13T19:46:40Z\x1b[0m\x1b[2m out\x1b[0m\n" +
+				"   \x1b[2mno unixtime\x1b[0m\n"},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Summary{mu: &sync.Mutex{}, Records: &RecordReport{}}
+		var buf bytes.Buffer
+		if err := run(strings.NewReader(input), &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, tt.expect, buf.String())
+		} else {
+			t.Logf("[ OK ] => %s", tt.name)
+		}
+		if s.NumberOfMatchedLines != 1 {
+			t.Errorf("[ NG ] => %s: matched lines expect: 1 actual: %d", tt.name, s.NumberOfMatchedLines)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		fv     *FlagVariables
+		expect int
+	}{
+		{"unixtime found", "1720999999 a\nno unixtime\n", &FlagVariables{}, 0},
+		{"no unixtime", "no unixtime\n", &FlagVariables{}, EXIT_NO_MATCH},
+		{"empty input", "", &FlagVariables{}, EXIT_NO_MATCH},
+		{"summary only", "1720999999 a\n", &FlagVariables{summaryFlag: true}, 0},
+		{"filter matched", "1720999999 a\n", &FlagVariables{filterFrom: "2024-07-14T00:00:00Z"}, 0},
+		{"filter not matched", "1720999999 a\n", &FlagVariables{filterFrom: "2024-07-15T00:00:00Z"}, EXIT_NO_MATCH},
+		{"invert filter matched", "no unixtime\n", &FlagVariables{filterFrom: "2024-07-15T00:00:00Z", invertFlag: true}, 0},
+		{"--mark not matched", "1720999999 a\n", &FlagVariables{filterFrom: "2024-07-15T00:00:00Z", markFlag: true}, EXIT_NO_MATCH},
+		{"--quiet matched", "1720999999 a\n", &FlagVariables{filterFrom: "2024-07-14T00:00:00Z", quietFlag: true}, 0},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		if err := run(strings.NewReader(tt.input), &Output{Writer: io.Discard, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if actual := exitStatus(s, p); actual != tt.expect {
+			t.Errorf("[ NG ] => %s  expect: %d actual: %d", tt.name, tt.expect, actual)
+		} else {
+			t.Logf("[ OK ] => %s", tt.name)
+		}
+	}
+}
+
+func TestBuildHistogram(t *testing.T) {
+	inputs := []string{
+		"1720999999000 1720999939500",
+		"none",
+		"1720999999500",
+		"1721000300000",
+	}
+	tests := []struct {
+		name   string
+		width  string
+		expect string
+	}{
+		{"1m buckets", "1m",
+			"1m0s 2024-07-14T23:32:00Z:1/0 2024-07-14T23:33:00Z:2/2 2024-07-14T23:34:00Z:0/0 2024-07-14T23:35:00Z:0/0 2024-07-14T23:36:00Z:0/0 2024-07-14T23:37:00Z:0/0 2024-07-14T23:38:00Z:1/1"},
+		{"auto buckets", "auto",
+			"10s 2024-07-14T23:32:10Z:1/0 2024-07-14T23:32:20Z:0/0 2024-07-14T23:32:30Z:0/0 2024-07-14T23:32:40Z:0/0 2024-07-14T23:32:50Z:0/0 2024-07-14T23:33:00Z:0/0 2024-07-14T23:33:10Z:2/2 2024-07-14T23:33:20Z:0/0 2024-07-14T23:33:30Z:0/0 2024-07-14T23:33:40Z:0/0 2024-07-14T23:33:50Z:0/0 2024-07-14T23:34:00Z:0/0 2024-07-14T23:34:10Z:0/0 2024-07-14T23:34:20Z:0/0 2024-07-14T23:34:30Z:0/0 2024-07-14T23:34:40Z:0/0 2024-07-14T23:34:50Z:0/0 2024-07-14T23:35:00Z:0/0 2024-07-14T23:35:10Z:0/0 2024-07-14T23:35:20Z:0/0 2024-07-14T23:35:30Z:0/0 2024-07-14T23:35:40Z:0/0 2024-07-14T23:35:50Z:0/0 2024-07-14T23:36:00Z:0/0 2024-07-14T23:36:10Z:0/0 2024-07-14T23:36:20Z:0/0 2024-07-14T23:36:30Z:0/0 2024-07-14T23:36:40Z:0/0 2024-07-14T23:36:50Z:0/0 2024-07-14T23:37:00Z:0/0 2024-07-14T23:37:10Z:0/0 2024-07-14T23:37:20Z:0/0 2024-07-14T23:37:30Z:0/0 2024-07-14T23:37:40Z:0/0 2024-07-14T23:37:50Z:0/0 2024-07-14T23:38:00Z:0/0 2024-07-14T23:38:10Z:0/0 2024-07-14T23:38:20Z:1/1"},
+	}
+	for _, tt := range tests {
+		fv := &FlagVariables{summaryFlag: true, histogram: tt.width}
+		initializeFlagVariables(fv)
+		p, _ := validateFlagVariables(fv)
+		s := &Summary{mu: &sync.Mutex{}}
+		output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+		for i, line := range inputs {
+			input := &Input{Index: int64(i), Text: line}
+			processResult(output, replaceUnixtimeToDatetime(input, s, p))
+		}
+		h := buildHistogram(s, p)
+		actual := h.BucketWidth
+		for _, b := range h.Buckets {
+			actual += fmt.Sprintf(" %s:%d/%d", b.Start, b.NumberOfUnixtime, b.NumberOfLines)
+		}
+		if actual != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, actual)
+		} else {
+			t.Logf("[ OK ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, actual)
+		}
+	}
+}
+
+func TestAutoHistogramWidthMS(t *testing.T) {
+	tests := []struct {
+		name     string
+		periodMS int64
+		expect   time.Duration
+	}{
+		{"same unixtime", 0, time.Second},
+		{"59 seconds", 59 * 1000, time.Second},
+		{"60 seconds", 60 * 1000, 5 * time.Second},
+		{"1 hour", 3600 * 1000, 5 * time.Minute},
+		{"1 day", 86400 * 1000, 30 * time.Minute},
+		{"10 years", 3650 * 86400 * 1000, 61 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if actual := autoHistogramWidthMS(tt.periodMS); actual != tt.expect.Milliseconds() {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, time.Duration(actual)*time.Millisecond)
+		}
+	}
+}
+
+func TestDetectGaps(t *testing.T) {
+	fv := &FlagVariables{summaryFlag: true, gaps: "30s"}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}, Gaps: &GapReport{Threshold: "30s", Gaps: []*Gap{}}}
+	output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+	results := []*Result{
+		{Index: 0},
+		{Index: 1, Matches: matchesOf(1720999999000)},
+		{Index: 2, Matches: matchesOf(1720999939500)},
+		{Index: 3, Matches: matchesOf(1721000029000, 1721000060000)},
+		{Index: 4, Matches: matchesOf(1721000090000)},
+		{Index: 5, Matches: matchesOf(1721000120001)},
+	}
+	for _, result := range results {
+		processResult(output, result)
+	}
+	expect := []Gap{
+		{"2024-07-14T23:33:49.000Z", "2024-07-14T23:34:20.000Z", "31s", 4, 4},
+		{"2024-07-14T23:34:50.000Z", "2024-07-14T23:35:20.001Z", "30.001s", 5, 6},
+	}
+	if len(s.Gaps.Gaps) != len(expect) {
+		t.Fatalf("[ NG ] => expect: %v gaps actual: %v gaps", len(expect), len(s.Gaps.Gaps))
+	}
+	for i, gap := range s.Gaps.Gaps {
+		if *gap != expect[i] {
+			t.Errorf("[ NG ] => gap #%d\n  expect: %+v\n  actual: %+v", i, expect[i], *gap)
+		}
+	}
+}
+
+func matchesOf(unixtimes ...int64) []*Match {
+	var matches []*Match
+	for _, unixtime := range unixtimes {
+		matches = append(matches, &Match{Unixtime: unixtime})
+	}
+	return matches
+}
+
+func TestCheckOrder(t *testing.T) {
+	inputs := []string{
+		`{"a":1720999999,"b":{"t":"1720999990"}}`,
+		`no unixtime`,
+		`{"a":1720999998,"b":{"t":"1720999995"}}`,
+		`{"a":1720999997,"b":{"t":"1720999980"}}`,
+		`{"a":1720999999,"b":{"t":"1720999985"}}`,
+	}
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect OrderReport
+	}{
+		{"per line", &FlagVariables{checkOrder: "line"},
+			OrderReport{NumberOfOutOfOrder: 2, WorstRegression: "1s", WorstRegressionLine: 3, OutOfOrderLines: []int64{3, 4}}},
+		{"per line with tolerance", &FlagVariables{checkOrder: "line", orderTolerance: "1s"},
+			OrderReport{OutOfOrderLines: []int64{}}},
+		{"per key", &FlagVariables{checkOrder: "key"},
+			OrderReport{NumberOfOutOfOrder: 3, WorstRegression: "15s", WorstRegressionLine: 4, OutOfOrderLines: []int64{3, 4}}},
+		{"per key with tolerance", &FlagVariables{checkOrder: "key", orderTolerance: "1s"},
+			OrderReport{NumberOfOutOfOrder: 1, WorstRegression: "15s", WorstRegressionLine: 4, OutOfOrderLines: []int64{4}}},
+	}
+	for _, tt := range tests {
+		tt.fv.summaryFlag = true
+		initializeFlagVariables(tt.fv)
+		p, _ := validateFlagVariables(tt.fv)
+		s := &Summary{mu: &sync.Mutex{}, OutOfOrder: &OrderReport{OutOfOrderLines: []int64{}}}
+		output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+		for i, line := range inputs {
+			processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+		}
+		actual := fmt.Sprintf("%d %s %d %v", s.OutOfOrder.NumberOfOutOfOrder, s.OutOfOrder.WorstRegression, s.OutOfOrder.WorstRegressionLine, s.OutOfOrder.OutOfOrderLines)
+		expect := fmt.Sprintf("%d %s %d %v", tt.expect.NumberOfOutOfOrder, tt.expect.WorstRegression, tt.expect.WorstRegressionLine, tt.expect.OutOfOrderLines)
+		if actual != expect {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.name, expect, actual)
+		} else {
+			t.Logf("[ OK ] => %s\n  expect: %v\n  actual: %v", tt.name, expect, actual)
+		}
+	}
+}
+
+func TestCheckOrderLineWithDifferentKeys(t *testing.T) {
+	inputs := []string{
+		`{"a":1720999999}`,
+		`{"b":1720000000}`,
+		`1720000001,x`,
+	}
+	fv := &FlagVariables{checkOrder: "line", summaryFlag: true}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}, OutOfOrder: &OrderReport{OutOfOrderLines: []int64{}}}
+	output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+	for i, line := range inputs {
+		processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+	}
+	if s.OutOfOrder.NumberOfOutOfOrder != 1 || s.OutOfOrder.WorstRegressionLine != 2 {
+		t.Errorf("[ NG ] => out of order: %d worst line: %d", s.OutOfOrder.NumberOfOutOfOrder, s.OutOfOrder.WorstRegressionLine)
+	}
+}
+
+func TestJsonKeyPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		expect string
+	}{
+		{"not json", `ts: 1720999999`, ""},
+		{"number value", `{"ts":1720999999}`, "ts"},
+		{"string value", `{"ts" : "1720999999"}`, "ts"},
+		{"second key", `{"id":1,"ts":1720999999}`, "ts"},
+		{"nested object", `{"user":{"name":"a,b","created_at":1720999999}}`, "user.created_at"},
+		{"after nested object", `{"user":{"id":1},"ts":1720999999}`, "ts"},
+		{"array of objects", `{"items":[{"t":1},{"t":1720999999}]}`, "items.t"},
+		{"escaped quote in key", `{"a\"b":1720999999}`, `a\"b`},
+	}
+	for _, tt := range tests {
+		pos := strings.Index(tt.text, "1720999999")
+		if actual := newKeyScanner(tt.text).jsonKeyPath(pos); actual != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, actual)
+		}
+	}
+}
+
+func TestSummaryMatchedAndEmitted(t *testing.T) {
+	inputs := []string{
+		"1720999999 1721000005",
+		"none",
+		"1720999990",
+		"1721000010",
+	}
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		expect string
+	}{
+		{"without filter", &FlagVariables{summaryTo: "stderr"}, "4 4 4  "},
+		{"summary only", &FlagVariables{summaryFlag: true}, "4 4 0  "},
+		{"with filter", &FlagVariables{summaryTo: "stderr", filterFrom: "2024-07-14T23:33:19Z"},
+			"4 2 2 2024-07-14T23:33:19Z 2024-07-14T23:33:30Z"},
+		{"with invert filter", &FlagVariables{summaryTo: "stderr", filterFrom: "2024-07-14T23:33:19Z", invertFlag: true},
+			"4 2 2 2024-07-14T23:33:10Z 2024-07-14T23:33:10Z"},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+		for i, line := range inputs {
+			processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+		}
+		outputSummary(&bytes.Buffer{}, s, p)
+		actual := fmt.Sprintf("%d %d %d %s %s", s.TotalNumberOfLines, s.NumberOfMatchedLines, s.NumberOfEmittedLines, s.MatchedOldestDatetime, s.MatchedNewestDatetime)
+		if actual != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, actual)
+		} else {
+			t.Logf("[ OK ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, actual)
+		}
+	}
+}
+
+func TestOutputSummaryFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		expect string
+	}{
+		{"table", `TotalNumberOfLines                    2
+TotalNumberOfUnixtime                 1
+NumberOfLinesContainUnixtime          1
+NumberOfLinesWithoutUnixtime          1
+NumberOfMatchedLines                  2
+NumberOfEmittedLines                  0
+OldestDatetime                        2024-07-14T23:33:19Z
+NewestDatetime                        2024-07-14T23:33:19Z
+FilterCommandExample                  unix2date -f 2024-07-14T23:33:19Z -t 2024-07-14T23:33:19Z
+DurationSeconds                       0
+NumberOfDistinctUnixtime              1
+NumberOfUnixtimeByDetector.separator  1
+EventsPerSecond.Mean                  1
+EventsPerSecond.Peak                  1
+EventsPerSecond.PeakWindow            2024-07-14T23:33:19Z
+EventsPerMinute.Mean                  1
+EventsPerMinute.Peak                  1
+EventsPerMinute.PeakWindow            2024-07-14T23:33:00Z
+Histogram.BucketWidth                 1h0m0s
+Histogram.Buckets.0.Start             2024-07-14T23:00:00Z
+Histogram.Buckets.0.NumberOfUnixtime  1
+Histogram.Buckets.0.NumberOfLines     1
+`},
+		{"csv", `TotalNumberOfLines,TotalNumberOfUnixtime,NumberOfLinesContainUnixtime,NumberOfLinesWithoutUnixtime,NumberOfMatchedLines,NumberOfEmittedLines,OldestDatetime,NewestDatetime,FilterCommandExample,DurationSeconds,NumberOfDistinctUnixtime,NumberOfUnixtimeByDetector.separator,EventsPerSecond.Mean,EventsPerSecond.Peak,EventsPerSecond.PeakWindow,EventsPerMinute.Mean,EventsPerMinute.Peak,EventsPerMinute.PeakWindow,Histogram.BucketWidth,Histogram.Buckets.0.Start,Histogram.Buckets.0.NumberOfUnixtime,Histogram.Buckets.0.NumberOfLines
+2,1,1,1,2,0,2024-07-14T23:33:19Z,2024-07-14T23:33:19Z,unix2date -f 2024-07-14T23:33:19Z -t 2024-07-14T23:33:19Z,0,1,1,1,1,2024-07-14T23:33:19Z,1,1,2024-07-14T23:33:00Z,1h0m0s,2024-07-14T23:00:00Z,1,1
+`},
+		{"yaml", `TotalNumberOfLines: 2
+TotalNumberOfUnixtime: 1
+NumberOfLinesContainUnixtime: 1
+NumberOfLinesWithoutUnixtime: 1
+NumberOfMatchedLines: 2
+NumberOfEmittedLines: 0
+OldestDatetime: "2024-07-14T23:33:19Z"
+NewestDatetime: "2024-07-14T23:33:19Z"
+FilterCommandExample: "unix2date -f 2024-07-14T23:33:19Z -t 2024-07-14T23:33:19Z"
+DurationSeconds: 0
+NumberOfDistinctUnixtime: 1
+NumberOfUnixtimeByDetector:
+  separator: 1
+EventsPerSecond:
+  Mean: 1
+  Peak: 1
+  PeakWindow: "2024-07-14T23:33:19Z"
+EventsPerMinute:
+  Mean: 1
+  Peak: 1
+  PeakWindow: "2024-07-14T23:33:00Z"
+Histogram:
+  BucketWidth: "1h0m0s"
+  Buckets:
+    - Start: "2024-07-14T23:00:00Z"
+      NumberOfUnixtime: 1
+      NumberOfLines: 1
+`},
+		{"prom", `# HELP unix2date_lines_total Total number of lines.
+# TYPE unix2date_lines_total counter
+unix2date_lines_total 2
+# HELP unix2date_unixtime_total Total number of unixtime.
+# TYPE unix2date_unixtime_total counter
+unix2date_unixtime_total 1
+# HELP unix2date_lines_contain_unixtime_total Number of lines containing unixtime.
+# TYPE unix2date_lines_contain_unixtime_total counter
+unix2date_lines_contain_unixtime_total 1
+# HELP unix2date_lines_without_unixtime_total Number of lines without unixtime.
+# TYPE unix2date_lines_without_unixtime_total counter
+unix2date_lines_without_unixtime_total 1
+# HELP unix2date_matched_lines_total Number of lines matched with filter.
+# TYPE unix2date_matched_lines_total counter
+unix2date_matched_lines_total 2
+# HELP unix2date_emitted_lines_total Number of lines written to output.
+# TYPE unix2date_emitted_lines_total counter
+unix2date_emitted_lines_total 0
+# HELP unix2date_oldest_timestamp_seconds Oldest unixtime in seconds.
+# TYPE unix2date_oldest_timestamp_seconds gauge
+unix2date_oldest_timestamp_seconds 1720999999.321
+# HELP unix2date_newest_timestamp_seconds Newest unixtime in seconds.
+# TYPE unix2date_newest_timestamp_seconds gauge
+unix2date_newest_timestamp_seconds 1720999999.321
+`},
+	}
+	for _, tt := range tests {
+		fv := &FlagVariables{summaryFlag: true, summaryFormat: tt.format, histogram: "1h", statsFlag: true}
+		initializeFlagVariables(fv)
+		p, _ := validateFlagVariables(fv)
+		s := &Summary{mu: &sync.Mutex{}}
+		output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+		for i, line := range []string{"1720999999321", "none"} {
+			processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+		}
+		var buf bytes.Buffer
+		if err := outputSummary(&buf, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if actual := buf.String(); actual != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.format, tt.expect, actual)
+		}
+		if err := outputSummary(failingWriter{}, s, p); err == nil {
+			t.Errorf("[ NG ] => %s: write error is not returned", tt.format)
+		}
+	}
+}
+
+func TestSummaryYAMLKeys(t *testing.T) {
+	fv := &FlagVariables{summaryFlag: true, summaryFormat: "yaml"}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}}
+	output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+	processResult(output, replaceUnixtimeToDatetime(&Input{Text: `{"a b: c":1720999999,"user.created_at":1720999999,"null":1720999999}`}, s, p))
+	var buf bytes.Buffer
+	if err := outputSummary(&buf, s, p); err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"\n  \"a b: c\":\n", "\n  user.created_at:\n", "\n  \"null\":\n"} {
+		if !strings.Contains(buf.String(), expect) {
+			t.Errorf("[ NG ] => %q is not found in\n%s", expect, buf.String())
+		}
+	}
+}
+
+// failingWriter fails every write, like a full disk.
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, fmt.Errorf("no space left on device")
+}
+
+func TestBuildStatistics(t *testing.T) {
+	fv := &FlagVariables{summaryFlag: true, statsFlag: true}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}}
+	inputs := []string{
+		`1720999999000 "1720999999000"`,
+		`{"a":1720999999500}`,
+		`none`,
+		`1721000060000 1721000000000`,
+	}
+	output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+	for i, line := range inputs {
+		processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+	}
+	buildStatistics(s)
+	actual := fmt.Sprintf("%v %d %v %.4f/%d/%s %.4f/%d/%s %+v",
+		s.DurationSeconds, s.NumberOfDistinctUnixtime, s.NumberOfUnixtimeByDetector,
+		s.EventsPerSecond.Mean, s.EventsPerSecond.Peak, s.EventsPerSecond.PeakWindow,
+		s.EventsPerMinute.Mean, s.EventsPerMinute.Peak, s.EventsPerMinute.PeakWindow,
+		*s.IntervalMilliseconds)
+	expect := "61 4 map[json:1 quotation:1 separator:3] 0.0806/3/2024-07-14T23:33:19Z 2.5000/4/2024-07-14T23:33:00Z {P50:500 P90:60000 P99:60000 Max:60000}"
+	if actual != expect {
+		t.Errorf("[ NG ] =>\n  expect: %v\n  actual: %v", expect, actual)
+	}
+}
+
+func TestBuildStatisticsWithoutStats(t *testing.T) {
+	fv := &FlagVariables{summaryFlag: true}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}}
+	output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+	for i, line := range []string{`1720999999000 "1720999999000"`, `1721000060000 1721000000000`} {
+		processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+	}
+	buildStatistics(s)
+	// every distinct unixtime is kept only with --stats
+	if s.unixtimeCounts != nil || s.NumberOfDistinctUnixtime != 0 || s.EventsPerSecond != nil || s.IntervalMilliseconds != nil {
+		t.Errorf("[ NG ] => statistics are calculated without --stats")
+	}
+	if s.DurationSeconds != 61 || s.NumberOfUnixtimeByDetector["separator"] != 3 {
+		t.Errorf("[ NG ] => duration: %v detectors: %v", s.DurationSeconds, s.NumberOfUnixtimeByDetector)
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		expect string
+	}{
+		{"no key", `start 1720999999`, ""},
+		{"json key path", `{"user":{"created_at":1720999999}}`, "user.created_at"},
+		{"logfmt key", `level=info ts=1720999999 msg=ok`, "ts"},
+		{"quoted logfmt key", `level=info ts="1720999999" msg=ok`, "ts"},
+		{"csv column", `a,"b,c",1720999999,d`, "column3"},
+		{"first csv column", `1720999999,d`, "column1"},
+		{"quoted csv column", `a,"1720999999"`, "column2"},
+		{"comma in free text", `1720999999 started, ok`, ""},
+		{"comma after unixtime in free text", `at 1720999999, started`, ""},
+	}
+	for _, tt := range tests {
+		pos := strings.Index(tt.text, "1720999999")
+		if actual := newKeyScanner(tt.text).key(pos, pos+10); actual != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %v\n  actual: %v", tt.name, tt.expect, actual)
+		}
+	}
+}
+
+func TestKeyScannerIncremental(t *testing.T) {
+	texts := []string{
+		`{"a":1720999999,"b":{"c":[1720999999,{"d":1720999999}],"e":"1720999999 1720999999"},"f":1720999999}`,
+		`{"msg":"x\"1720999999\\","ts":1720999999}`,
+		`a,"b,1720999999",1720999999,ts=1720999999,"1720999999"`,
+		`level=info ts=1720999999 end="1720999999" 1720999999`,
+	}
+	for _, text := range texts {
+		k := newKeyScanner(text)
+		for pos := strings.Index(text, "1720999999"); pos >= 0; {
+			expect := newKeyScanner(text).key(pos, pos+10)
+			if actual := k.key(pos, pos+10); actual != expect {
+				t.Errorf("[ NG ] => %s at %d\n  expect: %v\n  actual: %v", text, pos, expect, actual)
+			}
+			next := strings.Index(text[pos+1:], "1720999999")
+			if next < 0 {
+				break
+			}
+			pos += next + 1
+		}
+	}
+}
+
+func TestSummaryKeys(t *testing.T) {
+	fv := &FlagVariables{summaryFlag: true}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}}
+	inputs := []string{
+		`{"created_at":1720999999,"updated_at":"1721000000","user":{"expires_at":1721999999}}`,
+		`{"created_at":1720999990,"updated_at":"1721000005"}`,
+		`1720999999`,
+	}
+	output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+	for i, line := range inputs {
+		processResult(output, replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, s, p))
+	}
+	buildStatistics(s)
+	var actual []string
+	for _, key := range []string{"created_at", "updated_at", "user.expires_at"} {
+		ks := s.Keys[key]
+		if ks == nil {
+			t.Fatalf("[ NG ] => key %s is not found", key)
+		}
+		actual = append(actual, fmt.Sprintf("%s:%d:%s:%s", key, ks.NumberOfUnixtime, ks.OldestDatetime, ks.NewestDatetime))
+	}
+	expect := []string{
+		"created_at:2:2024-07-14T23:33:10Z:2024-07-14T23:33:19Z",
+		"updated_at:2:2024-07-14T23:33:20Z:2024-07-14T23:33:25Z",
+		"user.expires_at:1:2024-07-26T13:19:59Z:2024-07-26T13:19:59Z",
+	}
+	if len(s.Keys) != 3 || strings.Join(actual, " ") != strings.Join(expect, " ") {
+		t.Errorf("[ NG ] =>\n  expect: %v\n  actual: %v (%d keys)", expect, actual, len(s.Keys))
+	}
+}
+
+func TestSortedRange(t *testing.T) {
+	// sorted lines with continuation lines, and a long run of lines without unixtime in the middle
+	var sb strings.Builder
+	var offsets, unixtimes []int64
+	for i := int64(0); i < 20000; i++ {
+		offsets = append(offsets, int64(sb.Len()))
+		unixtimes = append(unixtimes, (1720999999+i)*1000)
+		fmt.Fprintf(&sb, "%d INFO request id=%d\n", 1720999999+i, i)
+		if i%5 == 0 {
+			sb.WriteString("\tat com.example.Service.method(Service.java:10)\n")
+		}
+		if i == 10000 {
+			sb.WriteString(strings.Repeat("no unixtime\n", 20000))
+		}
+	}
+	data := sb.String()
+
+	// expect returns the offset of the first line whose unixtime is at or after targetMS
+	expect := func(targetMS int64) int64 {
+		for i, unixtime := range unixtimes {
+			if unixtime >= targetMS {
+				return offsets[i]
+			}
+		}
+		return int64(len(data))
+	}
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{"whole period", "2001-09-09T01:46:40Z", "2065-01-24T05:19:59Z"},
+		{"head", "2001-09-09T01:46:40Z", "2024-07-14T23:35:00Z"},
+		{"tail", "2024-07-15T05:00:00Z", "2065-01-24T05:19:59Z"},
+		{"middle", "2024-07-15T00:00:00Z", "2024-07-15T01:00:00.500Z"},
+		{"around lines without unixtime", "2024-07-15T02:20:00Z", "2024-07-15T02:20:00Z"},
+		{"before the first line", "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z"},
+		{"after the last line", "2030-01-01T00:00:00Z", "2030-01-02T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		fv := &FlagVariables{filterFrom: tt.from, filterTo: tt.to, sortedFlag: true}
+		initializeFlagVariables(fv)
+		p, err := validateFlagVariables(fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end, err := sortedRange(strings.NewReader(data), int64(len(data)), p)
+		expectStart, expectEnd := expect(p.filterFromMS), expect(p.filterToMS+1)
+		if err != nil || start != expectStart || end != expectEnd {
+			t.Errorf("[ NG ] => %s\n  expect: [%d, %d)\n  actual: [%d, %d) %v", tt.name, expectStart, expectEnd, start, end, err)
+		}
+	}
+}
+
+func TestRunInputSortedKeepsLineNumbers(t *testing.T) {
+	// lines skipped by --sorted are still counted in line numbers
+	var sb strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&sb, "%d line%d\n", 1720999999+i*60, i+1)
+	}
+	name := filepath.Join(t.TempDir(), "sorted.log")
+	if err := os.WriteFile(name, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, jobs := range []int{1, 4} {
+		fv := &FlagVariables{sortedFlag: true, filterFrom: "2024-07-14T23:43:19Z", maxCount: 2, outputFormat: "ndjson", summaryTo: "stderr", jobs: jobs}
+		initializeFlagVariables(fv)
+		p, err := validateFlagVariables(fv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		if err := runInput(name, &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, event := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			lines = append(lines, event[:strings.Index(event, `,"Source"`)])
+		}
+		actual := fmt.Sprintf("%v %d", lines, s.StoppedAtLine)
+		expect := `[{"Line":11 {"Line":12] 12`
+		if actual != expect {
+			t.Errorf("[ NG ] => -j %d\n  expect: %v\n  actual: %v", jobs, expect, actual)
+		}
+	}
+}
+
+func generateLogLines(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&sb, "%d INFO request id=%d took 12ms\n", 1720999999+i, i)
+		case 1:
+			fmt.Fprintf(&sb, `{"id":%d,"created_at":%d,"updated_at":"%d"}`+"\n", i, 1720999999000+int64(i), 1720999999000+int64(i))
+		case 2:
+			fmt.Fprintf(&sb, "\tat com.example.Service.method(Service.java:%d)\n", i)
+		case 3:
+			fmt.Fprintf(&sb, "%d,%d,user%d\n", 1720999999+i, 1721000000+i, i)
+		}
+	}
+	return sb.String()
+}
+
+func TestRunKeepsOrder(t *testing.T) {
+	input := generateLogLines(20000)
+	tests := []struct {
+		name string
+		fv   *FlagVariables
+	}{
+		{"default", &FlagVariables{}},
+		{"sequential", &FlagVariables{jobs: 1}},
+		{"small reorder window", &FlagVariables{jobs: 4, reorderLines: 3}},
+		{"reorder window smaller than a line", &FlagVariables{jobs: 4, reorderBytes: "10"}},
+	}
+	for _, tt := range tests {
+		tt.fv.filterFrom = "2024-07-14T23:40:00Z"
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+
+		var expect strings.Builder
+		for i, line := range strings.Split(strings.TrimSuffix(input, "\n"), "\n") {
+			result := replaceUnixtimeToDatetime(&Input{Index: int64(i), Text: line}, &Summary{mu: &sync.Mutex{}}, p)
+			if result.NeedToOutput {
+				expect.WriteString(result.Text + "\n")
+			}
+		}
+
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		if err := run(strings.NewReader(input), &Output{Writer: &buf, Param: p, Summary: s}, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expect.String() {
+			t.Errorf("[ NG ] => %s: output differs from sequential conversion", tt.name)
+		}
+		if s.TotalNumberOfLines != 20000 || s.NumberOfEmittedLines != int64(strings.Count(expect.String(), "\n")) {
+			t.Errorf("[ NG ] => %s: lines: %d emitted: %d", tt.name, s.TotalNumberOfLines, s.NumberOfEmittedLines)
+		}
+	}
+}
+
+// endlessReader returns lines with the same unixtime forever, like a stream which never ends.
+type endlessReader struct {
+	line string
+}
+
+func (r *endlessReader) Read(b []byte) (int, error) {
+	n := 0
+	for n+len(r.line) <= len(b) {
+		n += copy(b[n:], r.line)
+	}
+	return n, nil
+}
+
+func TestRunStopsAfterRange(t *testing.T) {
+	// sorted lines followed by a line within the period which must not be read,
+	// and endless lines after the period
+	var sb strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "%d INFO request id=%d\n", 1720999999+i, i)
+		if i == 15000 {
+			sb.WriteString("continued\n")
+		}
+	}
+	sb.WriteString("1721002000 unsorted\n")
+	tests := []struct {
+		name         string
+		fv           *FlagVariables
+		expectLines  int64
+		expectLineNo int64
+	}{
+		{"default", &FlagVariables{stopAfter: 1}, 1002, 15404},
+		{"sequential", &FlagVariables{jobs: 1}, 1002, 15404},
+		{"small reorder window", &FlagVariables{jobs: 4, reorderLines: 3}, 1002, 15404},
+		{"stop after 3 lines", &FlagVariables{stopAfter: 3}, 1002, 15406},
+	}
+	for _, tt := range tests {
+		tt.fv.filterFrom = "2024-07-15T03:33:19Z" // 1721014399 (line 14401)
+		tt.fv.filterTo = "2024-07-15T03:50:00Z"   // 1721015400 (line 15403)
+		tt.fv.sortedFlag = true
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		r := io.MultiReader(strings.NewReader(sb.String()), &endlessReader{line: "1721100000 after\n"})
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		output := &Output{Writer: &buf, Param: p, Summary: s}
+		if err := run(r, output, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if !output.Stopped || !s.StoppedEarly || s.NumberOfEmittedLines != tt.expectLines || strings.Contains(buf.String(), "unsorted") {
+			t.Errorf("[ NG ] => %s: stopped: %v emitted: %d", tt.name, s.StoppedEarly, s.NumberOfEmittedLines)
+		}
+		if s.StoppedAtLine != tt.expectLineNo {
+			t.Errorf("[ NG ] => %s: expect: %d actual: %d", tt.name, tt.expectLineNo, s.StoppedAtLine)
+		}
+	}
+}
+
+func TestRunWithMaxCount(t *testing.T) {
+	// endless lines within the period, which must not be read to the end
+	tests := []struct {
+		name         string
+		fv           *FlagVariables
+		input        string
+		expect       string
+		expectLineNo int64
+	}{
+		{"-m 3", &FlagVariables{maxCount: 3},
+			"no unixtime\n1720999999 a\n1720999999 b\n1720999999 c\n",
+			"no unixtime\n2024-07-14T23:33:19Z a\n2024-07-14T23:33:19Z b\n", 3},
+		{"-m 2 with -f", &FlagVariables{maxCount: 2, filterFrom: "2024-07-14T00:00:00Z"},
+			"1720000000 out\n1720999999 a\n1720000000 out\n1720999999 b\n",
+			"2024-07-14T23:33:19Z a\n2024-07-14T23:33:19Z b\n", 4},
+		{"-m 1 with -A 2", &FlagVariables{maxCount: 1, afterContext: 2, filterFrom: "2024-07-14T00:00:00Z"},
+			"1720000000 out\n1720999999 a\n1720000000 out\n",
+			"2024-07-14T23:33:19Z a\n2024-07-03T09:46:40Z out\n2024-07-14T23:33:19Z endless\n", 4},
+		{"-m 2 with -j 1", &FlagVariables{maxCount: 2, jobs: 1},
+			"1720999999 a\n",
+			"2024-07-14T23:33:19Z a\n2024-07-14T23:33:19Z endless\n", 2},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		r := io.MultiReader(strings.NewReader(tt.input), &endlessReader{line: "1720999999 endless\n"})
+		s := &Summary{mu: &sync.Mutex{}}
+		var buf bytes.Buffer
+		output := &Output{Writer: &buf, Param: p, Summary: s}
+		if err := run(r, output, s, p); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.expect || !s.StoppedEarly || s.StoppedAtLine != tt.expectLineNo {
+			t.Errorf("[ NG ] => %s: stopped at: %d\n  expect: %q\n  actual: %q", tt.name, s.StoppedAtLine, tt.expect, buf.String())
+		}
+	}
+}
+
+func TestRunWithMaxCountSummary(t *testing.T) {
+	// lines read ahead by workers after -m is reached must not be counted in summary
+	input := "1720000000 out\n1720999999 a\n1720000000 out\n1720999999 b\n"
+	for _, jobs := range []int{1, 4, 16} {
+		fv := &FlagVariables{maxCount: 2, filterFrom: "2024-07-14T00:00:00Z", summaryTo: "stderr", jobs: jobs}
+		initializeFlagVariables(fv)
+		p, err := validateFlagVariables(fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => -j %d: %v", jobs, err)
+		}
+		r := io.MultiReader(strings.NewReader(input), &endlessReader{line: "1720999999 endless\n"})
+		s := &Summary{mu: &sync.Mutex{}}
+		output := &Output{Writer: &bytes.Buffer{}, Param: p, Summary: s}
+		if err := run(r, output, s, p); err != nil {
+			t.Fatal(err)
+		}
+		actual := fmt.Sprintf("%d %d %d %d %d %d", s.TotalNumberOfLines, s.TotalNumberOfUnixtime, s.NumberOfMatchedLines,
+			s.NumberOfEmittedLines, s.StoppedAtLine, s.MatchedNewestUnixtime)
+		expect := "4 4 2 2 4 1720999999000"
+		if actual != expect {
+			t.Errorf("[ NG ] => -j %d\n  expect: %v\n  actual: %v", jobs, expect, actual)
+		}
+	}
+}
+
+func TestRunWithCount(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}
+	os.WriteFile(files[0], []byte("1720999999 a\n1720000000 out\n1720999999 b\n"), 0644)
+	os.WriteFile(files[1], []byte("1720000000 out\n"), 0644)
+	tests := []struct {
+		name   string
+		fv     *FlagVariables
+		files  []string
+		expect string
+	}{
+		{"single file", &FlagVariables{countFlag: true, filterFrom: "2024-07-14T00:00:00Z"}, files[:1], "2\n"},
+		{"several files", &FlagVariables{countFlag: true, filterFrom: "2024-07-14T00:00:00Z"}, files,
+			files[0] + ":2\n" + files[1] + ":0\n"},
+		{"with -i", &FlagVariables{countFlag: true, filterFrom: "2024-07-14T00:00:00Z", invertFlag: true}, files,
+			files[0] + ":1\n" + files[1] + ":1\n"},
+		{"with -m", &FlagVariables{countFlag: true, maxCount: 1, filterFrom: "2024-07-14T00:00:00Z"}, files,
+			files[0] + ":1\n" + files[1] + ":0\n"},
+		{"with -m and -i", &FlagVariables{countFlag: true, maxCount: 1, filterFrom: "2024-07-14T00:00:00Z", invertFlag: true}, files,
+			files[0] + ":1\n" + files[1] + ":1\n"},
+		{"with -m per file", &FlagVariables{countFlag: true, maxCount: 1}, files,
+			files[0] + ":1\n" + files[1] + ":1\n"},
+		{"with records", &FlagVariables{countFlag: true, inheritFlag: true, filterFrom: "2024-07-14T00:00:00Z"}, files,
+			files[0] + ":2\n" + files[1] + ":0\n"},
+	}
+	for _, tt := range tests {
+		initializeFlagVariables(tt.fv)
+		p, err := validateFlagVariables(tt.fv)
+		if err != nil {
+			t.Fatalf("[ NG ] => %s: %v", tt.name, err)
+		}
+		s := &Summary{mu: &sync.Mutex{}, Records: &RecordReport{}}
+		var buf bytes.Buffer
+		output := &Output{Writer: &buf, Param: p, Summary: s}
+		for _, name := range tt.files {
+			if err := runInput(name, output, s, p); err != nil {
+				t.Fatal(err)
+			}
+			outputCount(output, name, len(tt.files) > 1)
+			if output.Stopped {
+				break
+			}
+		}
+		if buf.String() != tt.expect {
+			t.Errorf("[ NG ] => %s\n  expect: %q\n  actual: %q", tt.name, tt.expect, buf.String())
+		}
+	}
+}
+
+func TestReorderWindow(t *testing.T) {
+	w := newReorderWindow(10, 100)
+	acquired := make(chan *Chunk, 3)
+	chunks := []*Chunk{
+		{Seq: 0, Bytes: 60, Inputs: make([]*Input, 5)},
+		{Seq: 1, Bytes: 30, Inputs: make([]*Input, 5)},
+		{Seq: 2, Bytes: 20, Inputs: make([]*Input, 1)},
+	}
+	go func() {
+		for _, chunk := range chunks {
+			w.acquire(chunk)
+			acquired <- chunk
+		}
+	}()
+	<-acquired
+	<-acquired
+	select {
+	case <-acquired:
+		t.Fatalf("[ NG ] => chunk exceeding the window is acquired")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.release(chunks[0])
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("[ NG ] => chunk is not acquired after release")
+	}
+}
+
+// runGoroutinePerLine is the former pipeline which spawns a goroutine per line,
+// kept to compare the throughput with run.
+func runGoroutinePerLine(r io.Reader, w io.Writer, s *Summary, p *Parameter) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var index int64
+	bufResults := map[int64]*Result{}
+	flush := func(result *Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		if result != nil {
+			bufResults[result.Index] = result
+		}
+		for tmpRes, ok := bufResults[index]; ok; tmpRes, ok = bufResults[index] {
+			if tmpRes.NeedToOutput {
+				fmt.Fprintln(w, tmpRes.Text)
+			}
+			delete(bufResults, index)
+			index++
+		}
+	}
+	limiter := make(chan struct{}, runtime.NumCPU())
+	scanner := bufio.NewScanner(r)
+	for lineCount := int64(0); scanner.Scan(); lineCount++ {
+		input := &Input{Index: lineCount, Text: scanner.Text()}
+		limiter <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			flush(replaceUnixtimeToDatetime(input, s, p))
+		}()
+	}
+	wg.Wait()
+	flush(nil)
+}
+
+func BenchmarkRun(b *testing.B) {
+	fv := &FlagVariables{}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	input := generateLogLines(100000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := &Summary{mu: &sync.Mutex{}}
+		writer := bufio.NewWriterSize(io.Discard, OUTPUT_BUFFER_SIZE)
+		run(strings.NewReader(input), &Output{Writer: writer, Param: p, Summary: s}, s, p)
+		writer.Flush()
+	}
+}
+
+func BenchmarkRunGoroutinePerLine(b *testing.B) {
+	fv := &FlagVariables{}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	input := generateLogLines(100000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runGoroutinePerLine(strings.NewReader(input), io.Discard, &Summary{mu: &sync.Mutex{}}, p)
+	}
+}
+
+// The regexp based detection of the baseline replaced by Detector, kept verbatim
+// as the reference implementation. Each unixtime is found by rescanning the whole
+// line from the start with the patterns in order of priority.
+
+type ReplacePattern struct {
+	Regexp *regexp.Regexp
+	Type   int
+}
+
+const UNIXTIME_PATTERN = `([12](?:\d{12}|\d{9}))`
+
+func generateReplacePatternList(quotations, separators string) []ReplacePattern {
+	var replacePatterns []ReplacePattern
+	if len(separators) > 0 {
+		regexStr := `(?:^|[` + separators + `])` + UNIXTIME_PATTERN + `(?:[` + separators + `]|$)`
+		replacePattern := ReplacePattern{
+			Regexp: regexp.MustCompile(regexStr),
+			Type:   TYPE_SP,
+		}
+		replacePatterns = append(replacePatterns, replacePattern)
+	}
+	if len(quotations) > 0 {
+		regexStr := `(?:[` + quotations + `])` + UNIXTIME_PATTERN + `(?:[` + quotations + `])`
+		replacePattern := ReplacePattern{
+			Regexp: regexp.MustCompile(regexStr),
+			Type:   TYPE_QT,
+		}
+		replacePatterns = append(replacePatterns, replacePattern)
+	}
+	replacePattern := ReplacePattern{
+		Regexp: regexp.MustCompile(`(?:" *:) *` + UNIXTIME_PATTERN + ` *(?:[,}]|$)`),
+		Type:   TYPE_JSON,
+	}
+	replacePatterns = append(replacePatterns, replacePattern)
+	return replacePatterns
+}
+
+func getReplaceInfo(text string, replacePatterns []ReplacePattern) *ReplaceInfo {
+	for _, rp := range replacePatterns {
+		if textMatch := rp.Regexp.FindStringSubmatchIndex(text); textMatch != nil {
+			startIndex := textMatch[2]
+			endIndex := textMatch[3]
+			unixtimeStr := text[startIndex:endIndex]
+			var timeFormat string
+			if len(unixtimeStr) == 10 {
+				timeFormat = DATETIME_FORMAT10
+			} else if len(unixtimeStr) == 13 {
+				timeFormat = DATETIME_FORMAT13
+			}
+			replaceInfo := &ReplaceInfo{
+				UnixtimeStr: unixtimeStr,
+				StartIndex:  startIndex,
+				EndIndex:    endIndex,
+				TimeFormat:  timeFormat,
+			}
+			if rp.Type == TYPE_JSON {
+				replaceInfo.NeedQuote = true
+			}
+			return replaceInfo
+		}
+	}
+	return nil
+}
+
+// replaceUnixtimeWithRegexp is the conversion loop of the baseline without summary.
+func replaceUnixtimeWithRegexp(text string, replacePatterns []ReplacePattern) string {
+	for {
+		ri := getReplaceInfo(text, replacePatterns)
+		if ri == nil {
+			break
+		}
+
+		var targetTime time.Time
+		unixtime, _ := strconv.Atoi(ri.UnixtimeStr)
+		if len(ri.UnixtimeStr) == 10 {
+			targetTime = time.Unix(int64(unixtime), 0)
+		} else if len(ri.UnixtimeStr) == 13 {
+			targetTime = time.Unix(0, int64(unixtime)*int64(time.Millisecond))
+		}
+		datetimeStr := targetTime.UTC().Format(ri.TimeFormat)
+		if ri.NeedQuote {
+			datetimeStr = `"` + datetimeStr + `"`
+		}
+		text = text[:ri.StartIndex] + datetimeStr + text[ri.EndIndex:]
+	}
+	return text
+}
+
+// compileReplacePatternList is generateReplacePatternList returning false instead
+// of panicking on characters which the baseline cannot compile.
+func compileReplacePatternList(quotations, separators string) (patterns []ReplacePattern, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return generateReplacePatternList(quotations, separators), true
+}
+
+func FuzzReplaceUnixtimeToDatetime(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"1720999999",
+		"1720999999 1722543769876",
+		"a,1720999999,1722543769",
+		"\t1720999999\t",
+		`"1720999999":1720999999`,
+		`{"a":1720999999,"b": 1722543769876 ,"c":"1720999999"}`,
+		`{"a": {"b":1720999999}}`,
+		"17209999991720999999321",
+		"=1234567890 .1234567890 _1234567890",
+		"あ1722543769･1722543769876／",
+		"１７２２５４３７６９",
+		"\xff1720999999\xfe",
+	} {
+		f.Add(seed, DEF_SEPARATORS, DEF_QUOTATIONS)
+	}
+	for _, seed := range []struct{ text, separators, quotations string }{
+		{"17209999990172099999901", "0", DEF_QUOTATIONS},
+		{"917209999999", DEF_SEPARATORS, "9"},
+		{"a1720999999b", `\d`, DEF_QUOTATIONS},
+		{"a1720999999b'1720999999'", "ab", "'"},
+		{"-1720999999-:1720999999Z", "-Z", ":"},
+		{"(1720999999)", "", "()"},
+		{"1720999999 1720999999", "", ""},
+		{"x1720999999y", "^ ", DEF_QUOTATIONS},
+		{"あ1720999999い", "あ-う", DEF_QUOTATIONS},
+		{"\xff1720999999\xfe", "\ufffd", DEF_QUOTATIONS},
+	} {
+		f.Add(seed.text, seed.separators, seed.quotations)
+	}
+	f.Fuzz(func(t *testing.T, text, separators, quotations string) {
+		fv := &FlagVariables{}
+		initializeFlagVariables(fv)
+		fv.separators, fv.quotations = separators, quotations
+		p, err := validateFlagVariables(fv)
+		if err != nil {
+			// characters rejected by unix2date, ex. digits
+			return
+		}
+		patterns, ok := compileReplacePatternList(quotations, separators)
+		if !ok {
+			t.Fatalf("separators: %q quotations: %q accepted but the baseline cannot compile them", separators, quotations)
+		}
+		s := &Summary{mu: &sync.Mutex{}}
+		expect := replaceUnixtimeWithRegexp(text, patterns)
+		if actual := replaceUnixtimeToDatetime(&Input{Text: text}, s, p); actual.Text != expect {
+			t.Errorf("input: %q separators: %q quotations: %q\n  expect: %q\n  actual: %q", text, separators, quotations, expect, actual.Text)
+		}
+	})
+}
+
+func generateDenseLine(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `{"id":%d,"created_at":%d,"updated_at":"%d"} %d,`, i, 1720999999000+int64(i), 1720999999000+int64(i), 1720999999+i)
+	}
+	return sb.String()
+}
+
+func BenchmarkReplaceUnixtimeToDatetime(b *testing.B) {
+	fv := &FlagVariables{}
+	initializeFlagVariables(fv)
+	p, _ := validateFlagVariables(fv)
+	s := &Summary{mu: &sync.Mutex{}}
+	input := &Input{Text: generateDenseLine(100)}
+	b.SetBytes(int64(len(input.Text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		replaceUnixtimeToDatetime(input, s, p)
+	}
+}
+
+func BenchmarkReplaceUnixtimeWithRegexp(b *testing.B) {
+	patterns := generateReplacePatternList(DEF_QUOTATIONS, DEF_SEPARATORS)
+	text := generateDenseLine(100)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		replaceUnixtimeWithRegexp(text, patterns)
+	}
+}